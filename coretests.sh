@@ -0,0 +1,36 @@
+# script to compile the core tests, then run them using the Haxe interpreter, in each of the ways that exercise a different part of the compiler,
+# then as C++ with goroutines running on several threads (-D gothreads), which requires hxcpp,
+# then check that the nil check of the inlined wrapper in testNilCheckElim() is removed,
+# and finally check that runaway recursion gives the Go stack overflow error, that deadlocks are reported with the state of each goroutine,
+# that a goroutine blocked after main calls runtime.Goexit is a deadlock,
+# that a Go main function waiting for a call from a host that uses Go.pump() is not,
+# and that a panic in a call from callAsync() with no panic callback ends the program
+# must be run from tardisgo package directory, any output other than the build configuration signals an error
+go install github.com/tardisgo/tardisgo || exit 1
+cd tests/core
+for flags in "-debug" "-debug -inline=20" "-debug -preempt"; do
+	echo "tardisgo $flags"
+	tardisgo $flags test.go && haxe -main tardis.Go --no-inline --interp && haxe -main tardis.Go --no-inline --interp -D goasync
+done
+echo "haxe -D gothreads -cpp"
+haxe -main tardis.Go -D gothreads -cpp cpp > /dev/null && GOMAXPROCS=4 ./cpp/Go
+echo "nil check elimination"
+tardisgo -inline=20 -stats test.go 2>&1 | grep -q "nil checks removed: [1-9]" || echo "no nil checks removed"
+cd ../stackoverflow
+echo "stack overflow"
+tardisgo main.go && haxe -main tardis.Go --no-inline --interp 2>&1 | grep -q "fatal error: stack overflow" || echo "no stack overflow error"
+cd ../deadlock
+echo "deadlock"
+out=$(tardisgo main.go && haxe -main tardis.Go --no-inline --interp 2>&1)
+for want in "fatal error: all goroutines are asleep - deadlock!" "[chan send]:" "[chan receive]:" "Go_main_waitForever()"; do
+	echo "$out" | grep -qF "$want" || echo "no \"$want\" in the deadlock report"
+done
+cd ../goexitdeadlock
+echo "deadlock after runtime.Goexit"
+tardisgo main.go && haxe -main tardis.Go --no-inline --interp 2>&1 | grep -q "all goroutines are asleep - deadlock!" || echo "no deadlock error"
+cd ../goasync
+echo "host driven"
+tardisgo main.go && haxe -main Host --no-inline --interp 2>&1 | grep -q "received from host" || echo "the Go main function did not receive from the host"
+cd ../asyncpanic
+echo "callAsync panic"
+tardisgo main.go && haxe -main tardis.Go --no-inline --interp 2>&1 | grep -q "panic: nothing handles this" || echo "no panic from callAsync"
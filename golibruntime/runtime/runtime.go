@@ -8,12 +8,22 @@ package runtime
 
 // THE GOLANG RUNTIME PACKAGE IS NOT CURRENTLY ALL USABLE
 
-import "github.com/tardisgo/tardisgo/tardisgolib"
+import (
+	"github.com/tardisgo/tardisgo/tardisgolib"
+	"github.com/tardisgo/tardisgo/tardisgolib/hx"
+)
 
 func init() { // make calls in here to protect against Dead Code Elimination
 	// NOTE: only working code included here for now
 	Gosched()
 	NumGoroutine()
+	GOMAXPROCS(0)
+	Caller(0)
+	Callers(0, nil)
+	FuncForPC(0)
+	funcline_go(nil, 0)
+	funcname_go(nil)
+	funcentry_go(nil)
 }
 
 // Gosched implements runtime.Goshed
@@ -22,16 +32,24 @@ func Gosched() { tardisgolib.Gosched() }
 // NumGoroutine emulates runtime.NumGoroutine
 func NumGoroutine() int { return tardisgolib.NumGoroutine() }
 
+// GOMAXPROCS implements runtime.GOMAXPROCS, the setting only has an effect when goroutines run on several threads (-D gothreads)
+func GOMAXPROCS(n int) int { return hx.CodeInt("Scheduler.setMaxProcs(_a.itemAddr(0).load().val);", n) }
+
 //	EVERYTHING BELOW NOT YET IMPLEMENTED
 
 // TEST TEST this is a kludge
 //var sizeof_C_MStats int
 
-// Goexit unimplemented
-func Goexit() { panic("runtime.Goexit() not yet implemented") }
+// Goexit implements runtime.Goexit
+func Goexit() { tardisgolib.Goexit() }
 
-// FuncForPC not implemented
-func FuncForPC(pc uintptr) (uip *uintptr) { panic("runtime.FuncForPC() not yet implemented") }
+// FuncForPC implements runtime.FuncForPC for the pc values given by Caller and Callers, the *Func points to a copy of the pc
+func FuncForPC(pc uintptr) (uip *uintptr) {
+	if !hx.CodeBool("Scheduler.pcFunc(_a.itemAddr(0).load().val)!=null;", pc) {
+		return nil
+	}
+	return &pc
+}
 
 // SetFinalizer NoOp
 func SetFinalizer(x, f interface{}) {
@@ -39,15 +57,28 @@ func SetFinalizer(x, f interface{}) {
 	// used in init process, so must be NoOp for now
 }
 
-// implemented in symtab.c in GC runtime package
-func funcline_go(*uintptr /* should be *runtime.Func*/, uintptr) (s string, i int) {
-	panic("runtime.funcline_go() not yet implemented")
+// implemented in symtab.c in GC runtime package, these give the results of the methods of *runtime.Func
+func funcline_go(f *uintptr /* should be *runtime.Func*/, pc uintptr) (s string, i int) {
+	return pcFileLine(pc)
 }
-func funcname_go(*uintptr /* should be *runtime.Func*/) (s string) {
-	panic("runtime.funcname_go() not yet implemented")
+func funcname_go(f *uintptr /* should be *runtime.Func*/) (s string) {
+	if f == nil {
+		return ""
+	}
+	return hx.CodeString("Scheduler.pcFunc(_a.itemAddr(0).load().val).name;", *f)
 }
-func funcentry_go(*uintptr /* should be *runtime.Func*/) (uip uintptr) {
-	panic("runtime.funcentry_go() not yet implemented")
+func funcentry_go(f *uintptr /* should be *runtime.Func*/) (uip uintptr) {
+	if f == nil {
+		return 0
+	}
+	return hx.CodeDynamic("Scheduler.pcFunc(_a.itemAddr(0).load().val).entry;", *f)
+}
+
+// pcFileLine gives the source position of a pc value, the line is the start of the function unless compiled with -debug.
+func pcFileLine(pc uintptr) (file string, line int) {
+	file = hx.CodeString("Scheduler.pcFile(_a.itemAddr(0).load().val);", pc)
+	line = hx.CodeInt("Scheduler.pcLine(_a.itemAddr(0).load().val);", pc)
+	return
 }
 
 ////
@@ -68,7 +99,12 @@ func getgoroot() string {
 // program counter, file name, and line number within the file of the corresponding
 // call.  The boolean ok is false if it was not possible to recover the information.
 func Caller(skip int) (pc uintptr, file string, line int, ok bool) {
-	panic("runtime.Caller() not yet implemented")
+	pc = hx.CodeDynamic("Scheduler.callerPC(this._goroutine,this,_a.itemAddr(0).load().val);", skip)
+	if pc == 0 {
+		return
+	}
+	file, line = pcFileLine(pc)
+	return pc, file, line, true
 }
 
 // Callers fills the slice pc with the program counters of function invocations
@@ -77,5 +113,13 @@ func Caller(skip int) (pc uintptr, file string, line int, ok bool) {
 // 1 identifying the caller of Callers.
 // It returns the number of entries written to pc.
 func Callers(skip int, pc []uintptr) (i int) {
-	panic("runtime.Callers() not yet implemented")
+	for i < len(pc) {
+		p := hx.CodeDynamic("Scheduler.callerPC(this._goroutine,this,_a.itemAddr(0).load().val);", skip+i-1)
+		if p == 0 {
+			break
+		}
+		pc[i] = p
+		i++
+	}
+	return
 }
@@ -14,11 +14,17 @@ package atomic
 
 import (
 	"unsafe"
+
+	"github.com/tardisgo/tardisgo/tardisgolib/hx"
 )
 
-// Non-parallel basic replacement for the sync/atomic package
+// Basic replacement for the sync/atomic package, when goroutines run on several threads (-D gothreads) the operations use
+// the atomic instructions of the target, see Object.cas_int32() in the Haxe runtime
 //***********************************************************
 
+// addr is checked before it is used, so that a nil addr panics as in Go
+const nilchk = "Scheduler.wrapnilchk(_a.itemAddr(0).load().val)"
+
 // *********** ignore: +build !race
 
 // Package atomic provides low-level atomic memory primitives
@@ -52,123 +58,167 @@ import (
 
 // CompareAndSwapInt32 executes the compare-and-swap operation for an int32 value.
 func CompareAndSwapInt32(addr *int32, old, new int32) (swapped bool) {
-	if *addr == old {
-		*addr = new
-		return true
-	}
-	return false
+	return hx.CodeBool(nilchk+".cas_int32(_a.itemAddr(1).load().val,_a.itemAddr(2).load().val);", addr, old, new)
 }
 
 // CompareAndSwapInt64 executes the compare-and-swap operation for an int64 value.
 func CompareAndSwapInt64(addr *int64, old, new int64) (swapped bool) {
-	if *addr == old {
-		*addr = new
-		return true
-	}
-	return false
+	return hx.CodeBool(nilchk+".cas_int64(_a.itemAddr(1).load().val,_a.itemAddr(2).load().val);", addr, old, new)
 }
 
 // CompareAndSwapUint32 executes the compare-and-swap operation for a uint32 value.
 func CompareAndSwapUint32(addr *uint32, old, new uint32) (swapped bool) {
-	if *addr == old {
-		*addr = new
-		return true
-	}
-	return false
+	return hx.CodeBool(nilchk+".cas_int32(_a.itemAddr(1).load().val,_a.itemAddr(2).load().val);", addr, old, new)
 }
 
 // CompareAndSwapUint64 executes the compare-and-swap operation for a uint64 value.
 func CompareAndSwapUint64(addr *uint64, old, new uint64) (swapped bool) {
-	if *addr == old {
-		*addr = new
-		return true
-	}
-	return false
+	return hx.CodeBool(nilchk+".cas_int64(_a.itemAddr(1).load().val,_a.itemAddr(2).load().val);", addr, old, new)
 }
 
 // CompareAndSwapUintptr executes the compare-and-swap operation for a uintptr value.
 func CompareAndSwapUintptr(addr *uintptr, old, new uintptr) (swapped bool) {
-	if *addr == old {
-		*addr = new
-		return true
-	}
-	return false
+	return hx.CodeBool(nilchk+".cas_ref(_a.itemAddr(1).load().val,_a.itemAddr(2).load().val);", addr, old, new)
 }
 
 // CompareAndSwapPointer executes the compare-and-swap operation for a unsafe.Pointer value.
 func CompareAndSwapPointer(addr *unsafe.Pointer, old, new unsafe.Pointer) (swapped bool) {
-	if *addr == old {
-		*addr = new
-		return true
-	}
-	return false
+	return hx.CodeBool(nilchk+".cas_ref(_a.itemAddr(1).load().val,_a.itemAddr(2).load().val);", addr, old, new)
 }
 
 // AddInt32 atomically adds delta to *addr and returns the new value.
 func AddInt32(addr *int32, delta int32) (new int32) {
-	*addr += delta
-	return *addr
+	return int32(hx.CodeInt(nilchk+".add_int32(_a.itemAddr(1).load().val);", addr, delta))
 }
 
 // AddUint32 atomically adds delta to *addr and returns the new value.
 func AddUint32(addr *uint32, delta uint32) (new uint32) {
-	*addr += delta
-	return *addr
+	return uint32(hx.CodeInt(nilchk+".add_int32(_a.itemAddr(1).load().val);", addr, delta))
 }
 
+// The 64-bit and reference values are held as Haxe objects, so the operations on them below repeat until
+// the compare-and-swap succeeds. Swapping a value for itself gives an atomic load.
+
 // AddInt64 atomically adds delta to *addr and returns the new value.
 func AddInt64(addr *int64, delta int64) (new int64) {
-	*addr += delta
-	return *addr
+	for {
+		old := LoadInt64(addr)
+		new = old + delta
+		if CompareAndSwapInt64(addr, old, new) {
+			return
+		}
+	}
 }
 
 // AddUint64 atomically adds delta to *addr and returns the new value.
 func AddUint64(addr *uint64, delta uint64) (new uint64) {
-	*addr += delta
-	return *addr
+	for {
+		old := LoadUint64(addr)
+		new = old + delta
+		if CompareAndSwapUint64(addr, old, new) {
+			return
+		}
+	}
 }
 
 // AddUintptr atomically adds delta to *addr and returns the new value.
 func AddUintptr(addr *uintptr, delta uintptr) (new uintptr) {
-	*addr += delta
-	return *addr
+	for {
+		old := LoadUintptr(addr)
+		new = old + delta
+		if CompareAndSwapUintptr(addr, old, new) {
+			return
+		}
+	}
 }
 
 // LoadInt32 atomically loads *addr.
-func LoadInt32(addr *int32) (val int32) { return *addr }
+func LoadInt32(addr *int32) (val int32) {
+	return int32(hx.CodeInt(nilchk+".load_int32_atomic();", addr))
+}
 
 // LoadInt64 atomically loads *addr.
-func LoadInt64(addr *int64) (val int64) { return *addr }
+func LoadInt64(addr *int64) (val int64) {
+	hx.Code(nilchk+";", addr)
+	for {
+		val = *addr
+		if CompareAndSwapInt64(addr, val, val) {
+			return
+		}
+	}
+}
 
 // LoadUint32 atomically loads *addr.
-func LoadUint32(addr *uint32) (val uint32) { return *addr }
+func LoadUint32(addr *uint32) (val uint32) {
+	return uint32(hx.CodeInt(nilchk+".load_int32_atomic();", addr))
+}
 
 // LoadUint64 atomically loads *addr.
-func LoadUint64(addr *uint64) (val uint64) { return *addr }
+func LoadUint64(addr *uint64) (val uint64) {
+	hx.Code(nilchk+";", addr)
+	for {
+		val = *addr
+		if CompareAndSwapUint64(addr, val, val) {
+			return
+		}
+	}
+}
 
 // LoadUintptr atomically loads *addr.
-func LoadUintptr(addr *uintptr) (val uintptr) { return *addr }
+func LoadUintptr(addr *uintptr) (val uintptr) {
+	hx.Code(nilchk+";", addr)
+	for {
+		val = *addr
+		if CompareAndSwapUintptr(addr, val, val) {
+			return
+		}
+	}
+}
 
 // LoadPointer atomically loads *addr.
-func LoadPointer(addr *unsafe.Pointer) (val unsafe.Pointer) { return *addr }
+func LoadPointer(addr *unsafe.Pointer) (val unsafe.Pointer) {
+	hx.Code(nilchk+";", addr)
+	for {
+		val = *addr
+		if CompareAndSwapPointer(addr, val, val) {
+			return
+		}
+	}
+}
 
 // StoreInt32 atomically stores val into *addr.
-func StoreInt32(addr *int32, val int32) { *addr = val }
+func StoreInt32(addr *int32, val int32) {
+	hx.Code(nilchk+".store_int32_atomic(_a.itemAddr(1).load().val);", addr, val)
+}
 
 // StoreInt64 atomically stores val into *addr.
-func StoreInt64(addr *int64, val int64) { *addr = val }
+func StoreInt64(addr *int64, val int64) {
+	for !CompareAndSwapInt64(addr, LoadInt64(addr), val) {
+	}
+}
 
 // StoreUint32 atomically stores val into *addr.
-func StoreUint32(addr *uint32, val uint32) { *addr = val }
+func StoreUint32(addr *uint32, val uint32) {
+	hx.Code(nilchk+".store_int32_atomic(_a.itemAddr(1).load().val);", addr, val)
+}
 
 // StoreUint64 atomically stores val into *addr.
-func StoreUint64(addr *uint64, val uint64) { *addr = val }
+func StoreUint64(addr *uint64, val uint64) {
+	for !CompareAndSwapUint64(addr, LoadUint64(addr), val) {
+	}
+}
 
 // StoreUintptr atomically stores val into *addr.
-func StoreUintptr(addr *uintptr, val uintptr) { *addr = val }
+func StoreUintptr(addr *uintptr, val uintptr) {
+	for !CompareAndSwapUintptr(addr, LoadUintptr(addr), val) {
+	}
+}
 
 // StorePointer atomically stores val into *addr.
-func StorePointer(addr *unsafe.Pointer, val unsafe.Pointer) { *addr = val }
+func StorePointer(addr *unsafe.Pointer, val unsafe.Pointer) {
+	for !CompareAndSwapPointer(addr, LoadPointer(addr), val) {
+	}
+}
 
 // this only for the SSA compiler, will not be code generated
 func init() {
@@ -1,16 +1,45 @@
-// Package time is not implemented in TARDIS Go, this is not-fully-working TEST code, for OSX only
+// Package time provides the functions that the Go "time" standard library package needs from the runtime when used by TARDIS Go:
+// the current time, Sleep and timers, where sleeping goroutines are parked in the Scheduler.
 package time
 
 import "github.com/tardisgo/tardisgo/tardisgolib/hx"
 
+var keepTimers bool // never set, it only stops the code below being seen as unreachable
+
 func init() { // protect working code from DCE
-	//now() // NOT WORKING
+	now()
+	Sleep(0)
+	if keepTimers { // a timer started here would run a goroutine in every program that imports time
+		t := &runtimeTimer{}
+		startTimer(t)
+		stopTimer(t)
+	}
 }
 
 // Provided by package runtime.
 func now() (sec int64, nsec int32) {
-	return hx.CodeIface("int64", "GOint64.ofFloat(Date.now().getTime()/1000.0);").(int64),
-		hx.CodeIface("int32", "cast(Date.now().getTime()%1000.0,Int)*1000000;").(int32)
+	t := hx.CodeFloat("Scheduler.timeNow();") // seconds since 1970
+	sec = int64(t)
+	nsec = int32((t - float64(sec)) * 1e9)
+	return
+}
+
+// Sleep implements time.Sleep, the goroutine is parked in the Scheduler until the time has passed.
+func Sleep(d int64) {
+	if d <= 0 {
+		return
+	}
+	hx.Code("Scheduler.sleepUntil(this._goroutine,Scheduler.timeNow()+_a.itemAddr(0).load().val);", float64(d)/1e9)
+	gosched() // the Scheduler does not run the goroutine again until it wakes
+}
+
+// gosched gives up control, as tardisgolib.Gosched() does.
+func gosched() {
+	c := make(chan bool)
+	select {
+	case <-c:
+	default:
+	}
 }
 
 // Interface to timers implemented in package runtime.
@@ -23,9 +52,73 @@ type runtimeTimer struct {
 	arg    interface{}
 }
 
-func startTimer(*runtimeTimer) {
-	panic("time.startTimer() NOT IMPLEMENTED")
+var timerID int32 // the last timer identifier given out, held in runtimeTimer.i while the timer is active
+
+var timerGR = make(map[int32]int) // the goroutine running each active timer, by identifier
+
+// the timer state above, and runtimeTimer.i, is guarded by the Scheduler lock, as timers may be used on several threads (-D gothreads)
+func lockTimers()   { hx.Code("Scheduler.lock();") }
+func unlockTimers() { hx.Code("Scheduler.unlock();") }
+
+// startTimer runs each timer in its own goroutine, which sleeps until the timer is due.
+func startTimer(t *runtimeTimer) {
+	lockTimers()
+	timerID++
+	if timerID <= 0 {
+		timerID = 1
+	}
+	t.i = timerID
+	id := timerID
+	unlockTimers()
+	go runTimer(t, id)
+}
+
+// timerActive returns true if the timer still has the identifier it was started with, so has been neither stopped nor restarted.
+func timerActive(t *runtimeTimer, id int32) bool {
+	lockTimers()
+	active := t.i == id
+	unlockTimers()
+	return active
 }
-func stopTimer(*runtimeTimer) (b bool) {
-	panic("time.stopTimer() NOT IMPLEMENTED")
+
+func runTimer(t *runtimeTimer, id int32) {
+	lockTimers()
+	timerGR[id] = hx.CodeInt("this._goroutine;")
+	unlockTimers()
+	defer func() {
+		lockTimers()
+		delete(timerGR, id)
+		unlockTimers()
+	}()
+	for timerActive(t, id) { // the timer may have been stopped before the goroutine started
+		hx.Code("Scheduler.sleepUntil(this._goroutine,_a.itemAddr(0).load().val);", float64(t.when)/1e9)
+		gosched()
+		if !timerActive(t, id) { // stopped, or restarted in another goroutine
+			return
+		}
+		sec, nsec := now()
+		t.f(sec*1e9+int64(nsec), t.arg)
+		if t.period <= 0 || !timerActive(t, id) {
+			break
+		}
+		t.when += t.period
+	}
+	lockTimers()
+	if t.i == id {
+		t.i = 0
+	}
+	unlockTimers()
+}
+
+// stopTimer returns true if the timer was active, the goroutine of a stopped timer is woken so that it ends at once.
+func stopTimer(t *runtimeTimer) (b bool) {
+	lockTimers()
+	b = t.i != 0
+	gr, running := timerGR[t.i]
+	t.i = 0
+	unlockTimers()
+	if running {
+		hx.Code("Scheduler.wakeEarly(_a.itemAddr(0).load().val);", gr)
+	}
+	return
 }
@@ -13,6 +13,7 @@ import (
 	"code.google.com/p/go.tools/go/ssa"
 	"code.google.com/p/go.tools/go/types"
 	"github.com/tardisgo/tardisgo/pogo"
+	"github.com/tardisgo/tardisgo/tgossa"
 )
 
 var haxeStdSizes = types.StdSizes{
@@ -159,6 +160,9 @@ func (l langType) FuncStart(packageName, objectName string, fn *ssa.Function, po
 	}
 	ret += emitTrace(`New:` + l.LangName(packageName, objectName))
 	ret += "Scheduler.push(gr,this);\n}\n"
+	if fn.Recover != nil && usesGr { // where to resume after a deferred function recovers a panic
+		ret += fmt.Sprintf("override public function resumeRecover():Void {_Next=%d;}\n", fn.Recover.Index)
+	}
 
 	rTyp := ""
 	switch fn.Signature.Results().Len() {
@@ -183,7 +187,7 @@ func (l langType) FuncStart(packageName, objectName string, fn *ssa.Function, po
 		ret += "public inline function res():Dynamic {return null;}\n" // just to keep the interface definition happy
 	}
 
-	// call from haxe (TODO: maybe run in a new goroutine)
+	// call from haxe (see callAsync below to run in a new goroutine)
 	ret += "public static inline function callFromHaxe( "
 	for p := range fn.Params {
 		if p != 0 {
@@ -210,17 +214,40 @@ func (l langType) FuncStart(packageName, objectName string, fn *ssa.Function, po
 	ret += " {\n"
 	ret += "if(!Go.doneInit) Go.init();\n" // very defensive TODO remove this once everyone understands that Go.init() must be called first
 	ret += "var _sf=new Go_" + l.LangName(packageName, objectName)
-	ret += "(0,null" // NOTE calls from Haxe hijack goroutine 0, so the main go goroutine will be suspended for the duration
+	ret += "(Scheduler.makeGoroutine(),null" // NOTE calls from Haxe run in a new goroutine, so the other goroutines continue to run
 	for p := range fn.Params {
 		ret += ", "
 		ret += "p_" + pogo.MakeID(fn.Params[p].Name())
 	}
-	ret += ").run(); \nwhile(_sf._incomplete) Scheduler.runAll();\n" // TODO alter for multi-threading if ever implemented
+	ret += ");\nScheduler.runFromHaxe(_sf);\n" // run by the scheduler, so that a panic unwinds the stack
 	if fn.Signature.Results().Len() > 0 {
 		ret += "return _sf.res();\n"
 	}
 	ret += "}\n"
 
+	if isPublic { // call from haxe in a new goroutine, without waiting for the result
+		fTyp := rTyp
+		if fTyp == "" {
+			fTyp = "Dynamic" // the result is always null
+		}
+		ret += "public static function callAsync( "
+		for p := range fn.Params {
+			ret += "p_" + pogo.MakeID(fn.Params[p].Name()) + " : " + l.LangType(fn.Params[p].Type().Underlying(), false, fn.Params[p].Name()+position) + ", "
+		}
+		ret += "?_done:" + fTyp + "->Void) : GoFuture<" + fTyp + "> {\n"
+		ret += "if(!Go.doneInit) Go.init();\n"
+		ret += "var _fut=new GoFuture<" + fTyp + ">();\n"
+		ret += "if(_done!=null) _fut.then(_done);\n"
+		ret += "var _sf=new Go_" + l.LangName(packageName, objectName) + "(Scheduler.makeGoroutine(),null"
+		for p := range fn.Params {
+			ret += ", p_" + pogo.MakeID(fn.Params[p].Name())
+		}
+		ret += ");\n"
+		ret += "Scheduler.whenComplete(_sf,function(){_fut.complete(_sf.res());},_fut.fail);\n"
+		ret += "Go.asyncWake();\n"
+		ret += "return _fut;\n}\n"
+	}
+
 	// call from haxe go runtime - use current goroutine
 	ret += "public static inline function callFromRT( _gr"
 	for p := range fn.Params {
@@ -290,52 +317,43 @@ func (l langType) FuncStart(packageName, objectName string, fn *ssa.Function, po
 				case *ssa.Builtin:
 					//NoOp
 				default:
-					ret += fmt.Sprintf("var _SF%d:StackFrame", -pseudoNextReturnAddress) //TODO set correct type, or let Haxe determine
-					if usesGr {
-						ret += " #if js =null #end ;\n"
-					} else {
-						ret += "=null;\n" // need to initalize when using the native stack for these vars
+					frames := 1
+					if pogo.IsInlined(in) {
+						frames = pogo.InlinedCalls(in) // usually no stack frame is required
+					}
+					for ; frames > 0; frames-- {
+						ret += fmt.Sprintf("var _SF%d:StackFrame", -pseudoNextReturnAddress) //TODO set correct type, or let Haxe determine
+						if usesGr {
+							ret += " #if js =null #end ;\n"
+						} else {
+							ret += "=null;\n" // need to initalize when using the native stack for these vars
+						}
+						pseudoNextReturnAddress--
 					}
-					pseudoNextReturnAddress--
 				}
-			case *ssa.Send, *ssa.Select, *ssa.RunDefers, *ssa.Panic:
+			case *ssa.Send:
+				pseudoNextReturnAddress -= 2 // the second return address is used to wait for an unbuffered send to be received
+			case *ssa.Select:
+				pseudoNextReturnAddress--
+				for _, st := range in.(*ssa.Select).States {
+					if st.Dir == types.SendOnly {
+						pseudoNextReturnAddress-- // as for *ssa.Send
+						break
+					}
+				}
+			case *ssa.RunDefers, *ssa.Panic:
 				pseudoNextReturnAddress--
 			case *ssa.UnOp:
 				if in.(*ssa.UnOp).Op == token.ARROW {
 					pseudoNextReturnAddress--
 				}
 			}
-
-			reg := l.Value(in, pogo.CodePosition(in.Pos()))
-			if reg != "" {
-				// Underlying() not used in 2 lines below because of *ssa.(opaque type)
-				typ := l.LangType(in.(ssa.Value).Type(), false, reg+"@"+position)
-				init := l.LangType(in.(ssa.Value).Type(), true, reg+"@"+position) // this may be overkill...
-
-				if strings.HasPrefix(init, "{") || strings.HasPrefix(init, "new Pointer") ||
-					strings.HasPrefix(init, "new UnsafePointer") ||
-					strings.HasPrefix(init, "new Object") || strings.HasPrefix(init, "new Slice") ||
-					strings.HasPrefix(init, "new Chan") || strings.HasPrefix(init, "new Map") ||
-					strings.HasPrefix(init, "new Complex") || strings.HasPrefix(init, "GOint64.make") { // stop unnecessary initialisation
-					// all SSA registers are actually assigned to before use, so minimal initialisation is required, except for maps
-					init = "null"
-				}
-				if typ != "" {
-					switch len(*in.(ssa.Value).Referrers()) {
-					case 0: // don't allocate unused temporary variables
-					//case 1: // TODO optimization possible using register replacement but does not currenty work for: a,b=b,a+b, so code removed
-					default:
-						if usesGr {
-							init = " #if js =" + init + " #end " // only init in JS, to tell the var type for v8 opt
-						} else {
-							init = "=" + init // when not using goroutines, they all need initializing
-						}
-						ret += haxeVar(reg, typ, init, position, "FuncStart()") + "\n"
-					}
-				}
-			}
+			ret += l.declareRegister(in, position, usesGr)
 		}
 	}
+	pogo.InlinedInstrs(func(in ssa.Instruction) { // the registers of inlined function bodies
+		ret += l.declareRegister(in, position, usesGr)
+	})
 
 	//TODO optimise (again) for if only one block (as below) AND no calls (which create synthetic values for _Next)
 	//if len(fn.Blocks) > 1 { // if there is only one block then we don't need to track which one is next
@@ -359,6 +377,50 @@ func (l langType) FuncStart(packageName, objectName string, fn *ssa.Function, po
 	return ret
 }
 
+// declare the Haxe variable that holds an SSA register, if the instruction has a register that is used
+func (l langType) declareRegister(in ssa.Instruction, position string, usesGr bool) string {
+	reg := l.Value(in, pogo.CodePosition(in.Pos()))
+	if reg == "" {
+		return ""
+	}
+	// Underlying() not used in 2 lines below because of *ssa.(opaque type)
+	typ := l.LangType(registerType(in.(ssa.Value)), false, reg+"@"+position)
+	init := l.LangType(registerType(in.(ssa.Value)), true, reg+"@"+position) // this may be overkill...
+
+	if strings.HasPrefix(init, "{") || strings.HasPrefix(init, "new Pointer") ||
+		strings.HasPrefix(init, "new UnsafePointer") ||
+		strings.HasPrefix(init, "new Object") || strings.HasPrefix(init, "new Slice") ||
+		strings.HasPrefix(init, "new Chan") || strings.HasPrefix(init, "new Map") ||
+		strings.HasPrefix(init, "new Complex") || strings.HasPrefix(init, "GOint64.make") { // stop unnecessary initialisation
+		// all SSA registers are actually assigned to before use, so minimal initialisation is required, except for maps
+		init = "null"
+	}
+	if typ == "" {
+		return ""
+	}
+	switch len(*in.(ssa.Value).Referrers()) {
+	case 0: // don't allocate unused temporary variables
+		return ""
+	//case 1: // TODO optimization possible using register replacement but does not currenty work for: a,b=b,a+b, so code removed
+	default:
+		if usesGr {
+			init = " #if js =" + init + " #end " // only init in JS, to tell the var type for v8 opt
+		} else {
+			init = "=" + init // when not using goroutines, they all need initializing
+		}
+		return haxeVar(reg, typ, init, position, "FuncStart()") + "\n"
+	}
+}
+
+// registerType gives the Go type held in the register for a value,
+// which for a scalar allocation is the type pointed to, rather than the pointer.
+func registerType(v ssa.Value) types.Type {
+	if pogo.IsScalarAlloc(v) {
+		return v.Type().Underlying().(*types.Pointer).Elem()
+	}
+	return v.Type()
+}
+
 func (l langType) runFunctionCode(packageName, objectName, msg string) string {
 	ret := "public function run():Go_" + l.LangName(packageName, objectName) + " {\n"
 	ret += emitTrace(`Run: ` + l.LangName(packageName, objectName) + " " + msg)
@@ -437,6 +499,9 @@ func (l langType) BlockEnd(block []*ssa.BasicBlock, num int, emitPhi bool) strin
 	if emitPhi {
 		ret += fmt.Sprintf(" _Phi=%d;\n", num)
 	}
+	if pogo.PreemptFlag && fnUsesGr && !hadBlockReturn && tgossa.HasBackEdge(block[num]) {
+		ret += "if(Scheduler.preempt(this._goroutine))return this;\n" // _Next is already set, so the loop continues when the goroutine next runs
+	}
 	if !hadBlockReturn {
 		ret += "#if js return null; #end\n"
 	}
@@ -489,6 +554,9 @@ func (l langType) Value(v interface{}, errorInfo string) string {
 		_, c := l.Const(*ci, errorInfo)
 		return c
 	case *ssa.Parameter:
+		if arg := pogo.InlinedArg(v.(*ssa.Parameter)); arg != nil { // the function body has been inlined into its caller
+			return l.Value(arg, errorInfo)
+		}
 		return "p_" + pogo.MakeID(v.(*ssa.Parameter).Name())
 	//case *ssa.Capture:
 	//	for b := range v.(*ssa.Capture).Parent().FreeVars {
@@ -609,6 +677,9 @@ func (l langType) intTypeCoersion(t types.Type, v, errorInfo string) string {
 }
 
 func (l langType) Store(v1, v2 interface{}, errorInfo string) string {
+	if pogo.IsScalarAlloc(v1) {
+		return l.IndirectValue(v1, errorInfo) + "=" + l.IndirectValue(v2, errorInfo) + "; /* scalar */ "
+	}
 	return l.IndirectValue(v1, errorInfo) + ".store" + loadStoreSuffix(v2.(ssa.Value).Type().Underlying(), true) +
 		l.IndirectValue(v2, errorInfo) + ");" +
 		" /* " + v2.(ssa.Value).Type().Underlying().String() + " */ "
@@ -626,13 +697,32 @@ func (l langType) Send(v1, v2 interface{}, errorInfo string) string {
 	}
 	ret += emitTrace(fmt.Sprintf("Block:%d", nextReturnAddress))
 	// TODO panic if the chanel is null
-	ret += "if(!" + l.IndirectValue(v1, errorInfo) + ".hasSpace())return this;\n" // go round the loop again and wait if not OK
-	ret += l.IndirectValue(v1, errorInfo) + ".send(" + l.IndirectValue(v2, errorInfo) + ");"
+	ch := l.IndirectValue(v1, errorInfo)
+	ret += emitLock() + "\n"
+	ret += "if(!Channel.canSend(" + ch + ")){" + emitWait(`Channel.waitReason(`+ch+`,"chan send")`) + emitUnlock() + "return this;}\n" // go round the loop again and wait if not OK
+	ret += "Channel.send(" + ch + "," + l.IndirectValue(v2, errorInfo) + ",this._goroutine);\n"
+	ret += emitUnlock() + "\n"
 	nextReturnAddress-- // decrement to set new return address for next code generation
+	ret += emitReceivedWait()
 	hadBlockReturn = false
 	return ret
 }
 
+// emitReceivedWait returns the code to wait until a value sent on an unbuffered channel has been received,
+// which uses the next return address if the wait is required
+func emitReceivedWait() string {
+	wait := "if(Scheduler.isSending(this._goroutine)){" + emitWait(`"chan send"`) + "return this;}\n"
+	ret := fmt.Sprintf("_Next=%d;\n", nextReturnAddress)
+	ret += wait
+	ret += "#if js return null; } #end\n" // if there is no need to wait, go straight on to the code below
+	ret += emitUnseenPseudoBlocks()
+	ret += fmt.Sprintf("#if !js case %d: #end\n", nextReturnAddress)
+	ret += fmt.Sprintf("#if js function _Block_%d(){ #end\n", -nextReturnAddress)
+	ret += wait
+	nextReturnAddress--
+	return ret
+}
+
 func emitReturnHere() string {
 	ret := ""
 	ret += fmt.Sprintf("_Next=%d;\n", nextReturnAddress)
@@ -649,6 +739,19 @@ func emitReturnHere() string {
 	return ret
 }
 
+// emitWait returns the code to tell the scheduler why the goroutine cannot continue, used to report deadlocks
+func emitWait(reason string) string {
+	return "Scheduler.wait(this._goroutine," + reason + ");"
+}
+
+// emitLock and emitUnlock bracket each channel operation, so that its test and action are atomic when goroutines run on several threads
+func emitLock() string {
+	return "Scheduler.lock();"
+}
+func emitUnlock() string {
+	return "Scheduler.unlock();"
+}
+
 func emitUnseenPseudoBlocks() string {
 	ret := ""
 	if nextReturnAddress == pseudoBlockNext {
@@ -689,6 +792,7 @@ the selected operation was a receive and the receive successfully yielded a valu
 */
 func (l langType) Select(isSelect bool, register string, v interface{}, CommaOK bool, errorInfo string) string {
 	ret := emitReturnHere() // even if we are in a non-blocking select, we need to give the other goroutines a chance!
+	hasSend := false
 	if isSelect {
 		sel := v.(*ssa.Select)
 		if register == "" {
@@ -697,6 +801,7 @@ func (l langType) Select(isSelect bool, register string, v interface{}, CommaOK
 		}
 		ret += register + "=" + l.LangType(v.(ssa.Value).Type(), true, errorInfo) + ";\n" //initialize
 		ret += register + ".r0= -1;\n"                                                    // the returned index if nothing is found
+		ret += emitLock() + "\n"
 
 		// Spec requires a pseudo-random order to which item is processed
 		ret += fmt.Sprintf("{ var _states:Array<Bool> = new Array(); var _rnd=Std.random(%d);\n", len(sel.States))
@@ -704,17 +809,24 @@ func (l langType) Select(isSelect bool, register string, v interface{}, CommaOK
 			switch sel.States[s].Dir {
 			case types.SendOnly:
 				ch := l.IndirectValue(sel.States[s].Chan, errorInfo)
-				ret += fmt.Sprintf("_states[%d]=%s.hasSpace();\n", s, ch)
+				ret += fmt.Sprintf("_states[%d]=Channel.hasSpace(%s,this._goroutine);\n", s, ch)
 			case types.RecvOnly:
 				ch := l.IndirectValue(sel.States[s].Chan, errorInfo)
-				ret += fmt.Sprintf("_states[%d]=%s.hasContents();\n", s, ch)
+				ret += fmt.Sprintf("_states[%d]=Channel.hasContents(%s,this._goroutine);\n", s, ch)
 			default:
 				pogo.LogError(errorInfo, "Haxe", fmt.Errorf("select statement has invalid ChanDir"))
 				return ""
 			}
 		}
-		ret += fmt.Sprintf("for(_s in 0...%d) {var _i=(_s+_rnd)%s%d; if(_states[_i]) {%s.r0=_i; break;};}\n",
-			len(sel.States), "%", len(sel.States), register)
+		// a value that another select has handed to this goroutine must be received, as its sender has committed to it
+		for s := range sel.States {
+			if sel.States[s].Dir == types.RecvOnly {
+				ch := l.IndirectValue(sel.States[s].Chan, errorInfo)
+				ret += fmt.Sprintf("if(Channel.handedTo(%s,this._goroutine)) %s.r0=%d;\n", ch, register, s)
+			}
+		}
+		ret += fmt.Sprintf("if(%s.r0 == -1) for(_s in 0...%d) {var _i=(_s+_rnd)%s%d; if(_states[_i]) {%s.r0=_i; break;};}\n",
+			register, len(sel.States), "%", len(sel.States), register)
 		ret += fmt.Sprintf("switch(%s.r0){", register)
 		rxIdx := 0
 		for s := range sel.States {
@@ -723,10 +835,10 @@ func (l langType) Select(isSelect bool, register string, v interface{}, CommaOK
 			case types.SendOnly:
 				ch := l.IndirectValue(sel.States[s].Chan, errorInfo)
 				snd := l.IndirectValue(sel.States[s].Send, errorInfo)
-				ret += fmt.Sprintf("%s.send(%s);\n", ch, snd)
+				ret += fmt.Sprintf("Channel.selectSend(%s,%s,this._goroutine);\n", ch, snd)
 			case types.RecvOnly:
 				ch := l.IndirectValue(sel.States[s].Chan, errorInfo)
-				ret += fmt.Sprintf("{ var _v=%s.receive(%s); ", ch,
+				ret += fmt.Sprintf("{ var _v=Channel.receive(%s,%s,this._goroutine); ", ch,
 					l.LangType(sel.States[s].Chan.(ssa.Value).Type().Underlying().(*types.Chan).Elem().Underlying(), true, errorInfo))
 				ret += fmt.Sprintf("%s.r%d= _v.r0; ", register, 2+rxIdx)
 				rxIdx++
@@ -737,23 +849,43 @@ func (l langType) Select(isSelect bool, register string, v interface{}, CommaOK
 			}
 		}
 		ret += "};}\n" // end switch; _states, _rnd scope
+		recvWait, recvDone := "", ""
+		for s := range sel.States {
+			if sel.States[s].Dir == types.RecvOnly { // senders on unbuffered channels need to know which goroutines are waiting to receive
+				ch := l.IndirectValue(sel.States[s].Chan, errorInfo)
+				recvWait += "Channel.recvWait(" + ch + ",this._goroutine);"
+				recvDone += "Channel.recvDone(" + ch + ",this._goroutine);"
+			} else {
+				hasSend = true
+			}
+		}
 		if sel.Blocking {
-			ret += "if(" + register + ".r0 == -1) return this;\n"
+			reason := `"select"`
+			if len(sel.States) == 0 {
+				reason = `"select (no cases)"`
+			}
+			ret += "if(" + register + ".r0 == -1){" + recvWait + emitWait(reason) + emitUnlock() + "return this;}\n"
 		}
+		ret += recvDone + emitUnlock() + "\n"
 
 	} else {
-		ret += "if(" + l.IndirectValue(v, errorInfo) + ".hasNoContents())return this;\n" // go round the loop again and wait if not OK
+		ch := l.IndirectValue(v, errorInfo)
+		ret += emitLock() + "\n"
+		ret += "if(Channel.hasNoContents(" + ch + ",this._goroutine)){" + emitWait(`Channel.waitReason(`+ch+`,"chan receive")`) + emitUnlock() + "return this;}\n" // go round the loop again and wait if not OK
 		if register != "" {
 			ret += register + "="
 		}
-		ret += l.IndirectValue(v, errorInfo) + ".receive("
-		ret += l.LangType(v.(ssa.Value).Type().Underlying().(*types.Chan).Elem().Underlying(), true, errorInfo) + ")" // put correct result into register
+		ret += "Channel.receive(" + ch + ","
+		ret += l.LangType(v.(ssa.Value).Type().Underlying().(*types.Chan).Elem().Underlying(), true, errorInfo) + ",this._goroutine)" // put correct result into register
 		if !CommaOK {
 			ret += ".r0"
 		}
-		ret += ";"
+		ret += ";" + emitUnlock()
 	}
 	nextReturnAddress-- // decrement to set new return address for next code generation
+	if hasSend {
+		ret += emitReceivedWait()
+	}
 	return ret
 }
 func (l langType) RegEq(r string) string {
@@ -805,7 +937,9 @@ func (l langType) Call(register string, cc ssa.CallCommon, args []ssa.Value, isB
 		switch fnToCall { // TODO handle other built-in functions?
 		case "len", "cap":
 			switch args[0].Type().Underlying().(type) {
-			case *types.Chan, *types.Slice:
+			case *types.Chan:
+				return register + "Channel." + fnToCall + "(" + l.IndirectValue(args[0], errorInfo) + ");"
+			case *types.Slice:
 				if fnToCall == "len" {
 					return register + "({var _v=" + l.IndirectValue(args[0], errorInfo) + ";_v==null?0:_v.len();});"
 				}
@@ -813,7 +947,10 @@ func (l langType) Call(register string, cc ssa.CallCommon, args []ssa.Value, isB
 				return register + "({var _v=" + l.IndirectValue(args[0], errorInfo) + ";_v==null?0:_v.cap();});"
 			case *types.Array: // assume len
 				return register + l.IndirectValue(args[0], errorInfo /*, false*/) + ".length;"
-			case *types.Map: // assume len(map) - requires counting the itterator
+			case *types.Map: // assume len(map) - requires counting the itterator, unless it is a GoMap
+				if l.usesGoMap(args[0].Type().Underlying().(*types.Map), errorInfo) {
+					return register + "({var _v=" + l.IndirectValue(args[0], errorInfo) + ";_v==null?0:_v.len();});"
+				}
 				return register + l.IndirectValue(args[0], errorInfo) + "==null?0:{var _l:Int=0;" + // TODO remove two uses of same variable
 					"var _it=" + l.IndirectValue(args[0], errorInfo) + ".iterator();" +
 					"while(_it.hasNext()) {_l++; _it.next();};" +
@@ -831,16 +968,17 @@ func (l langType) Call(register string, cc ssa.CallCommon, args []ssa.Value, isB
 			if len(args) > 0 { // if there are more arguments to pass, add a comma
 				ret += ","
 			}
-		case "delete":
-			return register + l.IndirectValue(args[0], errorInfo) + ".remove(" + l.IndirectValue(args[1], errorInfo) + ");"
+		case "delete": // a no-op for a nil map
+			return register + "if(" + l.IndirectValue(args[0], errorInfo) + "!=null) " +
+				l.IndirectValue(args[0], errorInfo) + ".remove(" + l.IndirectValue(args[1], errorInfo) + ");"
 		case "append":
 			return register + l.append(args, errorInfo) + ";"
 		case "copy": //TODO rework & test
 			return l.copy(register, args, errorInfo) + ";"
 		case "close":
-			return register + "" + l.IndirectValue(args[0], errorInfo) + ".close();"
+			return register + "Channel.close(" + l.IndirectValue(args[0], errorInfo) + ");"
 		case "recover":
-			return register + "" + "Scheduler.recover(this._goroutine);"
+			return register + "" + "Scheduler.recover(this._goroutine,this);" // this identifies the caller, which must be the deferred function
 		case "real":
 			return register + "" + l.IndirectValue(args[0], errorInfo) + ".real;"
 		case "imag":
@@ -1101,7 +1239,10 @@ func (l langType) Call(register string, cc ssa.CallCommon, args []ssa.Value, isB
 }
 
 func (l langType) RunDefers(usesGr bool) string {
-	return doCall("", "this.runDefers();\n", usesGr)
+	// each deferred function is run in turn, coming back to the same place until there are none left
+	addr := nextReturnAddress
+	ret := doCall("", "this.runDefers();\n", usesGr)
+	return ret + fmt.Sprintf("if(this.runDefers()){_Next=%d;return this;}\n", addr)
 }
 
 func doCall(register, callCode string, usesGr bool) string {
@@ -1170,7 +1311,10 @@ func (l langType) Alloc(reg string, v interface{}, errorInfo string) string {
 		return reg + "=new " + ptrTyp +
 			"(" + l.LangType(typ, true, errorInfo) + ");"
 	*/
-	typ := v.(types.Type).Underlying().(*types.Pointer).Elem().Underlying()
+	typ := v.(*ssa.Alloc).Type().Underlying().(*types.Pointer).Elem().Underlying()
+	if pogo.IsScalarAlloc(v) {
+		return reg + "=" + l.scalarZero(typ, errorInfo) + "; /* scalar */ "
+	}
 	/*
 		switch typ.(type) {
 		case *types.Array:
@@ -1187,6 +1331,16 @@ func (l langType) Alloc(reg string, v interface{}, errorInfo string) string {
 		reg, haxeStdSizes.Sizeof(typ))
 }
 
+// scalarZero gives the zero value for a scalar allocation,
+// which must be the same as the value that would be loaded from a newly allocated Object.
+func (l langType) scalarZero(typ types.Type, errorInfo string) string {
+	switch typ.(type) {
+	case *types.Slice, *types.Map, *types.Chan:
+		return "null"
+	}
+	return l.LangType(typ, true, errorInfo)
+}
+
 func (l langType) MakeChan(reg string, v interface{}, errorInfo string) string {
 	typeElem := l.LangType(v.(*ssa.MakeChan).Type().Underlying().(*types.Chan).Elem().Underlying(), false, errorInfo)
 	size := l.IndirectValue(v.(*ssa.MakeChan).Size, errorInfo)
@@ -1210,41 +1364,32 @@ func (l langType) MakeSlice(reg string, v interface{}, errorInfo string) string
 	return reg + "=" + newSliceCode(typeElem, initElem, capacity, length, errorInfo, itemSize) + `;`
 }
 
-// TODO see http://tip.golang.org/doc/go1.2#three_index
-// TODO add third parameter when SSA code provides it to enable slice instructions to specify a capacity
-func (l langType) Slice(register string, x, lv, hv interface{}, errorInfo string) string {
+// Slice handles both the simple s[low:high] and full s[low:high:max] forms of slice expression,
+// a missing bound is given to the runtime as -1.
+func (l langType) Slice(register string, x, lv, hv, mv interface{}, errorInfo string) string {
 	xString := l.IndirectValue(x, errorInfo) // the target must be an array
 	if xString == "" {
 		xString = l.IndirectValue(x, errorInfo)
 	}
-	lvString := "0"
-	if lv != nil {
-		lvString = l.IndirectValue(lv, errorInfo)
-		switch lv.(ssa.Value).Type().Underlying().(*types.Basic).Kind() {
-		case types.Int64, types.Uint64:
-			lvString = "GOint64.toInt(" + lvString + ")"
-		}
-	}
-	hvString := "-1"
-	if hv != nil {
-		hvString = l.IndirectValue(hv, errorInfo)
-		switch hv.(ssa.Value).Type().Underlying().(*types.Basic).Kind() {
-		case types.Int64, types.Uint64:
-			hvString = "GOint64.toInt(" + hvString + ")"
-		}
-	}
+	lvString := l.sliceBound(lv, "0", errorInfo)
+	hvString := l.sliceBound(hv, "-1", errorInfo)
+	mvString := l.sliceBound(mv, "-1", errorInfo)
 	switch x.(ssa.Value).Type().Underlying().(type) {
 	case *types.Slice:
-		return register + "=" + xString + `.subSlice(` + lvString + `,` + hvString + `);`
+		return register + "=" + xString + `.subSlice(` + lvString + `,` + hvString + `,` + mvString + `);`
 	case *types.Pointer:
 		eleSz := "1" + arrayOffsetCalc(x.(ssa.Value).Type().Underlying().(*types.Pointer).Elem().Underlying().(*types.Array).Elem().Underlying())
+		aLen := fmt.Sprintf("%d", x.(ssa.Value).Type().Underlying().(*types.Pointer).Elem().Underlying().(*types.Array).Len())
+		if mv != nil { // slice the whole array, then limit the capacity
+			return register + "=new Slice(" + xString + ",0,-1," + aLen + "," + eleSz + `).subSlice(` +
+				lvString + `,` + hvString + `,` + mvString + `);`
+		}
 		return register + "=new Slice(" + xString + `,` + lvString + `,` + hvString + "," +
 			//xString + ".len(" + eleSz + ")" +
-			fmt.Sprintf("%d", x.(ssa.Value).Type().Underlying().(*types.Pointer).Elem().Underlying().(*types.Array).Len()) +
-			"," + eleSz + `);`
+			aLen + "," + eleSz + `);`
 	case *types.Basic: // assume a string is in need of slicing...
 		return register + "=Force.toRawString(this._goroutine,Force.toUTF8slice(this._goroutine," + xString +
-			`).subSlice(` + lvString + `,` + hvString + `)` + `);`
+			`).subSlice(` + lvString + `,` + hvString + `,-1)` + `);`
 	default:
 		pogo.LogError(errorInfo, "Haxe",
 			fmt.Errorf("haxe.Slice() - unhandled type: %v", reflect.TypeOf(x.(ssa.Value).Type().Underlying())))
@@ -1252,6 +1397,19 @@ func (l langType) Slice(register string, x, lv, hv interface{}, errorInfo string
 	}
 }
 
+// the code for a slice bound, or def if there is no bound
+func (l langType) sliceBound(v interface{}, def, errorInfo string) string {
+	if v == nil {
+		return def
+	}
+	ret := l.IndirectValue(v, errorInfo)
+	switch v.(ssa.Value).Type().Underlying().(*types.Basic).Kind() {
+	case types.Int64, types.Uint64:
+		ret = "GOint64.toInt(" + ret + ")"
+	}
+	return ret
+}
+
 //TODO test that index values are not 64 bit
 func (l langType) Index(register string, v1, v2 interface{}, errorInfo string) string {
 	typ := v1.(ssa.Value).Type().Underlying().(*types.Array).Elem().Underlying()
@@ -1317,7 +1475,8 @@ func (l langType) MakeMap(reg string, v interface{}, errorInfo string) string {
 }
 
 func (l langType) MapUpdate(Map, Key, Value interface{}, errorInfo string) string {
-	ret := l.IndirectValue(Map, errorInfo) + ".set("
+	ret := "if(" + l.IndirectValue(Map, errorInfo) + `==null) Scheduler.panicFromHaxe("assignment to entry in nil map");`
+	ret += l.IndirectValue(Map, errorInfo) + ".set("
 	ret += l.IndirectValue(Key, errorInfo) + ","
 	ret += l.IndirectValue(Value, errorInfo) + ");"
 	return ret
@@ -1349,7 +1508,7 @@ func (l langType) Lookup(reg string, Map, Key interface{}, commaOk bool, errorIn
 	case "GOint64", "Int", "Float", "Bool", "String", "Pointer", "Slice":
 		returnValue = "cast(" + returnValue + "," + ltEle + ")"
 	}
-	eleExists := l.IndirectValue(Map, errorInfo) + ".exists(" + keyString + ")"
+	eleExists := "(" + l.IndirectValue(Map, errorInfo) + "!=null&&" + l.IndirectValue(Map, errorInfo) + ".exists(" + keyString + "))" // nil maps are empty
 	if commaOk {
 		return reg + "=" + eleExists + "?{r0:" + returnValue + ",r1:true}:{r0:" + li + ",r1:false};"
 	}
@@ -1365,8 +1524,14 @@ func (l langType) Range(reg string, v interface{}, errorInfo string) string {
 	switch l.LangType(v.(ssa.Value).Type().Underlying(), false, errorInfo) {
 	case "String":
 		return reg + "={k:0,v:Force.toUTF8slice(this._goroutine," + l.IndirectValue(v, errorInfo) + ")" + "};"
-	default: // assume it is a Map {k: key itterator,m: the map,z: zero value of an entry}
-		return reg + "={k:" + l.IndirectValue(v, errorInfo) + ".keys(),m:" + l.IndirectValue(v, errorInfo) +
+	default:
+		if l.usesGoMap(v.(ssa.Value).Type().Underlying().(*types.Map), errorInfo) {
+			// {k: itterator over a snapshot of the entries, z: zero value of an entry}
+			return reg + "={k:(" + l.IndirectValue(v, errorInfo) + "==null?null:GoMap.keySnapshot(" + l.IndirectValue(v, errorInfo) + ".entries()))" +
+				",z:" + l.LangType(v.(ssa.Value).Type().Underlying().(*types.Map).Elem().Underlying(), true, errorInfo) + "};"
+		}
+		// assume it is a Map {k: key itterator over a snapshot of the keys,m: the map,z: zero value of an entry}
+		return reg + "={k:(" + l.IndirectValue(v, errorInfo) + "==null?null:GoMap.keySnapshot(" + l.IndirectValue(v, errorInfo) + ".keys())),m:" + l.IndirectValue(v, errorInfo) +
 			",z:" + l.LangType(v.(ssa.Value).Type().Underlying().(*types.Map).Elem().Underlying(), true, errorInfo) +
 			`,f:function(m:` + l.LangType(v.(ssa.Value).Type().Underlying(), false, errorInfo) + ",k:" +
 			l.LangType(v.(ssa.Value).Type().Underlying().(*types.Map).Key().Underlying(), false, errorInfo) + "):" +
@@ -1381,13 +1546,23 @@ func (l langType) Next(register string, v interface{}, isString bool, errorInfo
 			"if(" + l.IndirectValue(v, errorInfo) + ".k>=" + l.IndirectValue(v, errorInfo) + ".v.len()){r0:false,r1:0,r2:0};" +
 			"else {" +
 			"var _dr:{r0:Int,r1:Int}=Go_utf8_DecodeRune.callFromRT(this._goroutine," + l.IndirectValue(v, errorInfo) +
-			".v.subSlice(_thisK,-1));" +
+			".v.subSlice(_thisK,-1,-1));" +
 			l.IndirectValue(v, errorInfo) + ".k+=_dr.r1;" +
 			"{r0:true,r1:cast(_thisK,Int),r2:cast(_dr.r0,Int)};}};"
 	}
-	// otherwise it is a map itterator
-	return register + "={var _hn:Bool=" + l.IndirectValue(v, errorInfo) + ".k.hasNext();\n" +
-		"if(_hn){var _nxt=" + l.IndirectValue(v, errorInfo) + ".k.next();\n" +
+	// otherwise it is a map itterator, which skips keys deleted since the range started
+	if l.usesGoMap(v.(*ssa.Range).X.Type().Underlying().(*types.Map), errorInfo) {
+		return register + "={var _hn:Bool=false;var _nxt:GoMapEntry=null;\n" +
+			"while(" + l.IndirectValue(v, errorInfo) + ".k!=null&&" + l.IndirectValue(v, errorInfo) + ".k.hasNext()){_nxt=" + l.IndirectValue(v, errorInfo) + ".k.next();\n" +
+			"if(!_nxt.removed){_hn=true;break;}}\n" +
+			"if(_hn){\n" +
+			"{r0:true,r1:_nxt.k,r2:_nxt.v};\n" +
+			"}else{{r0:false,r1:null,r2:" + l.IndirectValue(v, errorInfo) + ".z};\n}};"
+	}
+	return register + "={var _hn:Bool=false;var _nxt=null;\n" +
+		"while(" + l.IndirectValue(v, errorInfo) + ".k!=null&&" + l.IndirectValue(v, errorInfo) + ".k.hasNext()){_nxt=" + l.IndirectValue(v, errorInfo) + ".k.next();\n" +
+		"if(" + l.IndirectValue(v, errorInfo) + ".m.exists(_nxt)){_hn=true;break;}}\n" +
+		"if(_hn){\n" +
 		"{r0:true,r1:_nxt,r2:" + l.IndirectValue(v, errorInfo) + ".f(" +
 		l.IndirectValue(v, errorInfo) + ".m,_nxt)};\n" +
 		"}else{{r0:false,r1:null,r2:" + l.IndirectValue(v, errorInfo) + ".z};\n}};"
@@ -1457,12 +1632,12 @@ func (l langType) SubFnCall(id int) string {
 }
 
 func (l langType) DeclareTempVar(v ssa.Value) string {
-	typ := l.LangType(v.Type(), false, "temp var declaration")
+	typ := l.LangType(registerType(v), false, "temp var declaration")
 	if typ == "" {
 		return ""
 	}
 	// NOTE testing has demonstrated that JS temp var init improves V8 optimization & so speeds-up subFns
-	init := l.LangType(v.Type(), true, "temp var declaration")
+	init := l.LangType(registerType(v), true, "temp var declaration")
 	if strings.HasPrefix(init, "new") || strings.HasPrefix(init, "{") || strings.HasPrefix(init, "GOint64") {
 		init = "null"
 	}
@@ -12,10 +12,7 @@ func (l langType) append(args []ssa.Value, errorInfo string) string {
 		source = "Force.toUTF8slice(this._goroutine," + source + ")" // if we have a string, we must convert it to a slice
 	}
 	target := l.IndirectValue(args[0], errorInfo)
-	ret := target + ".append(" + source + ")"
-	if target == "null" {
-		ret = source
-	}
+	ret := "Slice.appendSlices(" + target + "," + source + ")" // NOTE the target may be a nil slice, and must not be aliased to the source
 	//fmt.Printf("APPEND DEBUG: %s - %+v - %s\n", ulSize, args, ret)
 
 	return ret
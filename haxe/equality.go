@@ -0,0 +1,219 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package haxe
+
+import (
+	"fmt"
+
+	"code.google.com/p/go.tools/go/types"
+	"code.google.com/p/go.tools/go/types/typeutil"
+)
+
+// The code in this file generates Haxe expressions that implement Go equality and hashing for each type,
+// which are used by the TypeInfo.isEqual() and TypeInfo.hash() functions in the generated code.
+
+// The Go equality and hash of a type are only generated if the type can be the dynamic type of an interface value,
+// or is the key type of a map that uses the GoMap class, as Go == on other values is generated in-line, see codeBinOp().
+var ifaceTypes typeutil.Map                // the types given to MakeInterface
+var ifaceTypeNames = make(map[string]bool) // the names of the types given to the hx.*Iface() pseudo-functions
+
+// logIfaceType records that values of type t may be held in interfaces, so may be compared or hashed as their dynamic type.
+func logIfaceType(t types.Type) {
+	ifaceTypes.Set(t, true)
+}
+
+// maxUnrolledArray is the largest array length whose element comparisons are written out in-line, rather than in a loop.
+const maxUnrolledArray = 4
+
+// usesGoMap returns true if the map is implemented using the runtime GoMap class,
+// rather than a native Haxe Map, because its keys are not held as Haxe Int or String values.
+func (l langType) usesGoMap(m *types.Map, errorInfo string) bool {
+	switch l.LangType(m.Key().Underlying(), false, errorInfo) {
+	case "Int", "String":
+		return false
+	}
+	return true
+}
+
+// arrayElemSize gives the size in bytes of each element of an array, including any padding.
+func arrayElemSize(ele types.Type) int64 {
+	ent := types.NewVar(0, nil, "___temp", ele)
+	return haxeStdSizes.Offsetsof([]*types.Var{ent, ent})[1]
+}
+
+// eqCode returns Haxe code to compare the values a and b of Go type t for equality, or "" if t is not comparable.
+func (l langType) eqCode(t types.Type, a, b string) string {
+	switch t.Underlying().(type) {
+	case *types.Basic:
+		switch t.Underlying().(*types.Basic).Kind() {
+		case types.Int64, types.Uint64:
+			return "(GOint64.compare(" + a + "," + b + ")==0)"
+		case types.Complex64, types.Complex128:
+			return "Complex.eq(" + a + "," + b + ")"
+		default:
+			return "(" + a + "==" + b + ")"
+		}
+	case *types.Pointer:
+		return "Pointer.isSame(" + a + "," + b + ")"
+	case *types.Chan:
+		return "(" + a + "==" + b + ")"
+	case *types.Interface:
+		return "Interface.isEqual(" + a + "," + b + ")"
+	case *types.Struct, *types.Array:
+		if !types.Comparable(t) {
+			return ""
+		}
+		return l.eqAt(t, a, b, "0", 0)
+	}
+	return "" // slices, maps and functions are not comparable
+}
+
+// eqAt returns Haxe code to compare the values of type t held at offset off in the Objects a and b,
+// depth is used to make loop variable names unique.
+func (l langType) eqAt(t types.Type, a, b, off string, depth int) string {
+	switch t.Underlying().(type) {
+	case *types.Struct:
+		st := t.Underlying().(*types.Struct)
+		ret := ""
+		for f := 0; f < st.NumFields(); f++ {
+			if st.Field(f).Name() == "_" {
+				continue // blank fields are ignored by Go equality
+			}
+			if ret != "" {
+				ret += "&&"
+			}
+			ret += l.eqAt(st.Field(f).Type(), a, b, fmt.Sprintf("%s+%d", off, fieldOffset(st, f)), depth)
+		}
+		if ret == "" {
+			return "true"
+		}
+		return "(" + ret + ")"
+	case *types.Array:
+		at := t.Underlying().(*types.Array)
+		sz := arrayElemSize(at.Elem())
+		if at.Len() <= maxUnrolledArray {
+			ret := "true"
+			for i := int64(0); i < at.Len(); i++ {
+				ret += "&&" + l.eqAt(at.Elem(), a, b, fmt.Sprintf("%s+%d", off, i*sz), depth)
+			}
+			return "(" + ret + ")"
+		}
+		iv := fmt.Sprintf("_i%d", depth)
+		return fmt.Sprintf("({var _r%d:Bool=true;for(%s in 0...%d)if(!%s){_r%d=false;break;};_r%d;})",
+			depth, iv, at.Len(), l.eqAt(at.Elem(), a, b, fmt.Sprintf("%s+%s*%d", off, iv, sz), depth+1), depth, depth)
+	}
+	return l.eqCode(t, l.loadAt(t, a, off), l.loadAt(t, b, off))
+}
+
+// loadAt returns Haxe code to load a non-aggregate value of type t from offset off in Object obj.
+func (l langType) loadAt(t types.Type, obj, off string) string {
+	return obj + ".get" + loadStoreSuffix(t.Underlying(), false) + off + ")"
+}
+
+// hashCode returns Haxe code to hash the value v of Go type t, or "" if t is not comparable.
+// Values that are equal under Go equality must have the same hash.
+func (l langType) hashCode(t types.Type, v string) string {
+	switch t.Underlying().(type) {
+	case *types.Basic:
+		switch t.Underlying().(*types.Basic).Kind() {
+		case types.Bool:
+			return "(" + v + "?1:0)"
+		case types.Int64, types.Uint64:
+			return "GoMap.hashInt64(" + v + ")"
+		case types.Float32, types.Float64:
+			return "GoMap.hashFloat(" + v + ")"
+		case types.Complex64, types.Complex128:
+			return "GoMap.hashComplex(" + v + ")"
+		case types.String:
+			return "GoMap.hashString(" + v + ")"
+		case types.Uintptr, types.UnsafePointer:
+			return "GoMap.hashDynamic(" + v + ")"
+		default:
+			return "(" + v + "&0x3FFFFFFF)"
+		}
+	case *types.Pointer:
+		return "Pointer.hash(" + v + ")"
+	case *types.Chan:
+		return "(" + v + "==null?0:" + v + ".hashId())"
+	case *types.Interface:
+		return "Interface.hash(" + v + ")"
+	case *types.Struct, *types.Array:
+		if !types.Comparable(t) {
+			return ""
+		}
+		return l.hashAt(t, v, "0", 0)
+	}
+	return ""
+}
+
+// hashAt returns Haxe code to hash the value of type t held at offset off in Object obj.
+func (l langType) hashAt(t types.Type, obj, off string, depth int) string {
+	switch t.Underlying().(type) {
+	case *types.Struct:
+		st := t.Underlying().(*types.Struct)
+		ret := "0"
+		for f := 0; f < st.NumFields(); f++ {
+			if st.Field(f).Name() == "_" {
+				continue
+			}
+			ret = "((" + ret + "*31+" + l.hashAt(st.Field(f).Type(), obj, fmt.Sprintf("%s+%d", off, fieldOffset(st, f)), depth) + ")&0x3FFFFFFF)"
+		}
+		return ret
+	case *types.Array:
+		at := t.Underlying().(*types.Array)
+		sz := arrayElemSize(at.Elem())
+		iv := fmt.Sprintf("_i%d", depth)
+		return fmt.Sprintf("({var _h%d:Int=0;for(%s in 0...%d)_h%d=(_h%d*31+%s)&0x3FFFFFFF;_h%d;})",
+			depth, iv, at.Len(), depth, depth, l.hashAt(at.Elem(), obj, fmt.Sprintf("%s+%s*%d", off, iv, sz), depth+1), depth)
+	}
+	return l.hashCode(t, l.loadAt(t, obj, off))
+}
+
+// emitEqualityAndHash generates the TypeInfo functions that give Go equality and hashing for each type
+// that is held in an interface or is a GoMap key, out of the types used.
+func (l langType) emitEqualityAndHash(pteKeys []types.Type, id func(types.Type) interface{}) string {
+	var needed typeutil.Map
+	for _, T := range pteKeys {
+		if ifaceTypes.At(T) != nil || ifaceTypeNames[T.String()] {
+			needed.Set(T, true)
+		}
+		if m, ok := T.Underlying().(*types.Map); ok && l.usesGoMap(m, "emitEqualityAndHash()") {
+			needed.Set(m.Key(), true)
+		}
+	}
+	var keys []types.Type
+	for _, T := range pteKeys { // map key types are in pteKeys, see EmitTypeInfo()
+		if needed.At(T) != nil {
+			keys = append(keys, T)
+		}
+	}
+	pteKeys = keys
+	ret := "public static function isEqual(t:Int,a:Dynamic,b:Dynamic):Bool {\nswitch(t){" + "\n"
+	for _, T := range pteKeys {
+		if code := l.eqCode(T, "_a", "_b"); code != "" {
+			lt := l.LangType(T, false, "emitEqualityAndHash()")
+			ret += fmt.Sprintf("case %d: var _a:%s=a; var _b:%s=b; return %s;\n", id(T), lt, lt, code)
+		}
+	}
+	ret += `default: Scheduler.panicFromHaxe("runtime error: comparing uncomparable type "+getName(t)); return false;}}` + "\n"
+
+	ret += "public static function hash(t:Int,v:Dynamic):Int {\nswitch(t){" + "\n"
+	for _, T := range pteKeys {
+		if code := l.hashCode(T, "_v"); code != "" {
+			ret += fmt.Sprintf("case %d: var _v:%s=v; return %s;\n", id(T), l.LangType(T, false, "emitEqualityAndHash()"), code)
+		}
+	}
+	ret += `default: Scheduler.panicFromHaxe("runtime error: hash of unhashable type "+getName(t)); return 0;}}` + "\n"
+	return ret
+}
+
+// isAggregateOrPointer is true for the types whose Go equality differs from the Haxe == operator.
+func isAggregateOrPointer(t types.Type) bool {
+	switch t.Underlying().(type) {
+	case *types.Struct, *types.Array, *types.Pointer:
+		return true
+	}
+	return false
+}
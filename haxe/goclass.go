@@ -55,32 +55,25 @@ func (l langType) GoClassEnd(pkg *ssa.Package) string {
 	main := "public static var doneInit:Bool=false;\n"                                                          // flag to run this routine only once
 	main += "\npublic static function init() : Void {\ndoneInit=true;\nvar gr:Int=Scheduler.makeGoroutine();\n" // first goroutine number is always 0
 	main += `if(gr!=0) throw "non-zero goroutine number in init";` + "\n"                                       // first goroutine number is always 0, NOTE using throw as panic not setup
-
-	//NOTE HACK start
-	ap := pkg.Prog.AllPackages()
-	for p := range ap {
-		// fmt.Println("DEBUG: ", ap[p].Object.Name())
-		if ap[p].Object.Name() == "runtime" {
-			var memStats runtime.MemStats // see magic variable setting required below
-			// this magic number required to init the runtime module, may change in future versions
-			// see go/tip/go/src/pkg/runtime/mem.go:68
-			main += fmt.Sprintf("Go.runtime_sizeof_C_MStats.store(%d);\n", unsafe.Sizeof(memStats))
-			break
-		}
-	}
-	//NOTE HACK end
-
-	main += "var _sfgr=new Go_haxegoruntime_init(gr,[]).run();\n" //haxegoruntime.init() NOTE can't use callFromHaxe() as that would call this fn
+	main += runtimeMagic(pkg)
+	main += schedulerSettings()
+	main += "var _sfgr=new Go_haxegoruntime_init(gr,[]);\n" //haxegoruntime.init() NOTE can't use callFromHaxe() as that would call this fn
 	main += "while(_sfgr._incomplete) Scheduler.runAll();\n"
-	main += "var _sf=new Go_" + pkg.Object.Name() + `_init(gr,[]).run();` + "\n" //NOTE can't use callFromHaxe() as that would call this fn
+	main += "var _sf=new Go_" + pkg.Object.Name() + `_init(gr,[]);` + "\n" //NOTE can't use callFromHaxe() as that would call this fn
 	main += "while(_sf._incomplete) Scheduler.runAll();\n"
 	main += "Scheduler.doneInit=true;\n"
 	main += `Go.haxegoruntime_ZiLen.store_uint32('字'.length);` // value required by haxegoruntime to know what type of strings we have
 	main += "}\n"
 	// Haxe main function, only called in a go-only environment
 	main += "\npublic static function main() : Void {\n"
-	main += "Go_" + pkg.Object.Name() + `_main.callFromHaxe();` + "\n"
+	main += "#if goasync\nstartAsync();\n#else\n"
+	main += "if(!doneInit) init();\n"
+	main += "var _sf=new Go_" + pkg.Object.Name() + "_main(Scheduler.makeMainGoroutine(),[]);\n" // as callFromHaxe(), but noting the goroutine
+	main += "Scheduler.runFromHaxe(_sf);\n"
+	main += "#end\n"
+	main += "Scheduler.stopThreads();\n" // main has returned, so the program ends
 	main += "}\n"
+	main += l.asyncCode(pkg)
 
 	pos := "public static function CPos(pos:Int):String {\nvar prefix:String=\"\";\n"
 	pos += fmt.Sprintf(`if (pos==%d) return "(pogo.NoPosHash)";`, pogo.NoPosHash) + "\n"
@@ -99,6 +92,97 @@ func (l langType) GoClassEnd(pkg *ssa.Package) string {
 	return main + pos + "} // end Go class"
 }
 
+// schedulerSettings returns the code to apply the tardisgo flags that configure the Scheduler, before any Go code runs
+func schedulerSettings() string {
+	ret := ""
+	if pogo.StackLimit > 0 {
+		ret += fmt.Sprintf("Scheduler.maxStackDepth=%d;\n", pogo.StackLimit)
+	}
+	if pogo.PreemptFlag {
+		ret += "Scheduler.preemptive=true;\n"
+	}
+	return ret
+}
+
+// runtimeMagic gives the code required to set up the Go runtime package, if it is used
+func runtimeMagic(pkg *ssa.Package) string {
+	//NOTE HACK start
+	ap := pkg.Prog.AllPackages()
+	for p := range ap {
+		// fmt.Println("DEBUG: ", ap[p].Object.Name())
+		if ap[p].Object.Name() == "runtime" {
+			var memStats runtime.MemStats // see magic variable setting required below
+			// this magic number required to init the runtime module, may change in future versions
+			// see go/tip/go/src/pkg/runtime/mem.go:68
+			return fmt.Sprintf("Go.runtime_sizeof_C_MStats.store(%d);\n", unsafe.Sizeof(memStats))
+		}
+	}
+	//NOTE HACK end
+	return ""
+}
+
+// asyncCode gives the functions that run the Go code in time slices, returning control to the host between them,
+// so that a browser or Flash host does not freeze while the Go code runs.
+// Use Go.startAsync() to start the Go program, or Go.pump() if the host wants to control the time slices itself.
+// When the Go code is driven by the host, goroutines that are all waiting are not a deadlock,
+// as the host may yet call Go code that lets them continue.
+func (l langType) asyncCode(pkg *ssa.Package) string {
+	ret := "\npublic static var asyncSlice:Float=0.01; // the length of each time slice in seconds, when running asynchronously\n"
+	ret += "static var asyncStage:Int=0;\nstatic var asyncSF:StackFrame=null;\nstatic var asyncRunning:Bool=false;\n"
+	// pump() runs init() and main() in the same order as init() and main() above, but without waiting for them to complete
+	ret += "public static function pump(slice:Float):Bool { // returns false once the Go main function, and any callAsync() calls, have returned\n"
+	ret += "Scheduler.hostDriven=true;\n"
+	ret += "return runSlice(slice);\n}\n"
+	ret += "static function runSlice(slice:Float):Bool {\n"
+	ret += "var end:Float=Scheduler.timeNow()+slice;\n"
+	ret += "if(asyncStage==0 && doneInit) asyncStage=3; // init() has already been run, and main() is not run here\n"
+	ret += "while(true){\n"
+	ret += "while(asyncStage<3 && (asyncSF==null||!asyncSF._incomplete)){\n"
+	ret += "switch(asyncStage++){\n"
+	ret += "case 0:\ndoneInit=true;\nvar gr:Int=Scheduler.makeGoroutine();\n"
+	ret += `if(gr!=0) throw "non-zero goroutine number in init";` + "\n"
+	ret += runtimeMagic(pkg)
+	ret += schedulerSettings()
+	ret += "asyncSF=new Go_haxegoruntime_init(gr,[]);\n"
+	ret += "case 1:\nasyncSF=new Go_" + pkg.Object.Name() + "_init(0,[]);\n"
+	ret += "case 2:\nScheduler.doneInit=true;\n"
+	ret += `Go.haxegoruntime_ZiLen.store_uint32('字'.length);` + "\n"
+	ret += "asyncSF=new Go_" + pkg.Object.Name() + "_main(Scheduler.makeMainGoroutine(),[]);\n"
+	ret += "}}\n"
+	ret += "if(asyncStage==3 && (asyncSF==null||!asyncSF._incomplete) && Scheduler.watchCount()==0) return false;\n"
+	ret += "Scheduler.runAll();\n"
+	ret += "if(Scheduler.idling||Scheduler.timeNow()>=end) return true;\n"
+	ret += "}}\n"
+	// startAsync() calls pump() once per frame in a browser or Flash, otherwise it runs the program to completion
+	ret += "public static function startAsync():Void {\n"
+	ret += "asyncRunning=true;\n"
+	ret += "#if flash\n"
+	ret += "Scheduler.hostDriven=true;\n"
+	ret += "flash.Lib.current.addEventListener(flash.events.Event.ENTER_FRAME,asyncFrame);\n"
+	ret += "#elseif js\n"
+	ret += "Scheduler.hostDriven=true;\n"
+	ret += "asyncFrame(null);\n"
+	ret += "#else\n"
+	ret += "while(runSlice(asyncSlice)){} // nothing else can call the Go code, so if the goroutines all wait it is a deadlock\n"
+	ret += "#end\n}\n"
+	// asyncWake() is called by callAsync(), to make sure that the Go code is run in a browser or Flash, otherwise the host must call pump()
+	ret += "public static function asyncWake():Void {\n"
+	ret += "#if (flash || js)\nif(!asyncRunning) startAsync();\n#end\n}\n"
+	ret += "static function asyncFrame(_:Dynamic):Void {\n"
+	ret += "var more:Bool=pump(asyncSlice);\n"
+	ret += "if(!more) asyncRunning=false;\n"
+	ret += "#if flash\n"
+	ret += "if(!more) flash.Lib.current.removeEventListener(flash.events.Event.ENTER_FRAME,asyncFrame);\n"
+	ret += "#elseif js\n"
+	ret += "if(more) {\n"
+	ret += `if(untyped __js__("typeof window !== 'undefined' && typeof window.requestAnimationFrame === 'function'"))` + "\n"
+	ret += "untyped window.requestAnimationFrame(asyncFrame);\n"
+	ret += "else haxe.Timer.delay(function(){asyncFrame(null);},0);\n"
+	ret += "}\n"
+	ret += "#end\n}\n"
+	return ret
+}
+
 func (langType) Const(lit ssa.Const, position string) (typ, val string) {
 	if lit.Value == nil {
 		return "Dynamic", "null"
@@ -111,7 +111,7 @@ class Force { // TODO maybe this should not be a separate haxe class, as no non-
 		var r:Int=y;
 		switch(y) {
 		case 0:
-			Scheduler.panicFromHaxe("attempt to divide integer value by 0"); 
+			Scheduler.panicFromHaxe("runtime error: integer divide by zero"); 
 		case -1:
 			switch (byts) {
 			case 1:
@@ -201,6 +201,46 @@ class Force { // TODO maybe this should not be a separate haxe class, as no non-
 	
 }
 
+#if (gothreads && java)
+// When goroutines run on several threads in Java, the memory of each Object is held in java.util.concurrent.atomic arrays,
+// so that the sync/atomic operations can act on the values themselves, see Object.cas_int32()
+abstract GoIntVec(java.util.concurrent.atomic.AtomicIntegerArray) {
+	public inline function new(length:Int) this=new java.util.concurrent.atomic.AtomicIntegerArray(length);
+	@:arrayAccess public inline function get(i:Int):Int return this.get(i);
+	@:arrayAccess public inline function set(i:Int,v:Int):Int { this.set(i,v); return v; }
+	public inline function compareAndSet(i:Int,old:Int,v:Int):Bool return this.compareAndSet(i,old,v);
+	public inline function addAndGet(i:Int,delta:Int):Int return this.addAndGet(i,delta);
+	public static function blit(src:GoIntVec,srcPos:Int,dest:GoIntVec,destPos:Int,len:Int):Void { // like haxe.ds.Vector.blit()
+		if(src==dest && srcPos<destPos) {
+			var i:Int=len;
+			while(i>0) {
+				i--;
+				dest[destPos+i]=src[srcPos+i];
+			}
+		} else 
+			for(i in 0...len) 
+				dest[destPos+i]=src[srcPos+i];
+	}
+}
+abstract GoDynVec(java.util.concurrent.atomic.AtomicReferenceArray<Dynamic>) {
+	public inline function new(length:Int) this=new java.util.concurrent.atomic.AtomicReferenceArray<Dynamic>(length);
+	@:arrayAccess public inline function get(i:Int):Dynamic return this.get(i);
+	@:arrayAccess public inline function set(i:Int,v:Dynamic):Dynamic { this.set(i,v); return v; }
+	public inline function compareAndSet(i:Int,old:Dynamic,v:Dynamic):Bool return this.compareAndSet(i,old,v); // compares references
+	public static function blit(src:GoDynVec,srcPos:Int,dest:GoDynVec,destPos:Int,len:Int):Void { // like haxe.ds.Vector.blit()
+		if(src==dest && srcPos<destPos) {
+			var i:Int=len;
+			while(i>0) {
+				i--;
+				dest[destPos+i]=src[srcPos+i];
+			}
+		} else 
+			for(i in 0...len) 
+				dest[destPos+i]=src[srcPos+i];
+	}
+}
+#end
+
 // Object code
 // a single type of Go object
 @:keep
@@ -211,10 +251,16 @@ class Object { // this implementation will improve with typed array access
 		private var dVec4:haxe.ds.Vector<Dynamic>; // on 4-byte boundaries 
 		private var arrayBuffer:js.html.ArrayBuffer;
 		private var dView:js.html.DataView;
+	#elseif (gothreads && java)
+		private var dVec4:GoDynVec; 
+		private var iVec:GoIntVec; 
 	#else
 		private var dVec4:haxe.ds.Vector<Dynamic>; 
 		private var iVec:haxe.ds.Vector<Int>; 
 	#end
+	#if (gothreads && cpp)
+		static var refLock:GoMutex=new GoMutex(); // hxcpp has no atomic exchange of object references, see swap_if_same()
+	#end
 	private var length:Int;
 
 	public inline function new(byteSize:Int){ // size is in bytes
@@ -223,22 +269,25 @@ class Object { // this implementation will improve with typed array access
 			arrayBuffer = new js.html.ArrayBuffer(byteSize);
 			if(byteSize>0)
 				dView = new js.html.DataView(arrayBuffer,0,byteSize); // complains if size is 0, TODO review
+		#elseif (gothreads && java)
+			dVec4 = new GoDynVec(1+(byteSize>>2)); 
+			iVec = new GoIntVec(byteSize);
 		#else
 			dVec4 = new haxe.ds.Vector<Dynamic>(1+(byteSize>>2)); 
 			iVec = new haxe.ds.Vector<Int>(byteSize);
 		#end
 		length = byteSize;
-	}
-	public function isEqual(off:Int,target:Object,tgtOff:Int):Bool { // TODO check if correct, used by interface{} value comparison
-		trace("isEqual");
-		if((this.length-off)!=(target.length-tgtOff)) return false;
-		for(i in 0...(this.length-off)) {
-			if(this.get(i+off)!=target.get(i+tgtOff))
-				return false;
-			if(this.get_uint8(i+off)!=target.get_uint8(i+tgtOff))
-				return false;
+		uid = 0;
+	}
+	private var uid:Int; // a unique identifier for the object, only allocated if required for hashing
+	private static var nextUid:Int = 0;
+	public function hashId():Int {
+		if(uid==0) {
+			nextUid = (nextUid+1) & 0x3FFFFFFF;
+			if(nextUid==0) nextUid = 1;
+			uid = nextUid;
 		}
-		return true;
+		return uid;
 	}
 	private static function objBlit(src:Object,srcPos:Int,dest:Object,destPos:Int,size:Int):Void{
 		#if (js && dataview)
@@ -266,6 +315,9 @@ class Object { // this implementation will improve with typed array access
 					d+=1;
 				}
 			}
+		#elseif (gothreads && java)
+			GoDynVec.blit(src.dVec4,srcPos>>2, dest.dVec4, destPos>>2, 1+(size>>2)); 
+			GoIntVec.blit(src.iVec,srcPos, dest.iVec, destPos, size); 
 		#else
 			haxe.ds.Vector.blit(src.dVec4,srcPos>>2, dest.dVec4, destPos>>2, 1+(size>>2)); 
 			haxe.ds.Vector.blit(src.iVec,srcPos, dest.iVec, destPos, size); 
@@ -487,6 +539,94 @@ class Object { // this implementation will improve with typed array access
 	public inline function set_string(i:Int,v:String):Void { 
 		set(i,v); 
 	}
+	// The sync/atomic operations on the value at offset i. When goroutines run on several threads (-D gothreads), 
+	// the int32 and uint32 values in iVec use the target's atomic instructions, and the int64, uint64, uintptr and unsafe.Pointer 
+	// values in dVec4 are changed by an atomic exchange of references, see swap_if_same(). Otherwise these are plain accesses, 
+	// as only one goroutine runs at a time. The other sync/atomic operations on dVec4 values are loops around cas_int64() or cas_ref().
+	public function cas_int32(i:Int,old:Int,v:Int):Bool {
+		#if (gothreads && cpp)
+			return cpp.AtomicInt.exchangeIf(cpp.Pointer.arrayElem(iVec.toData(),i).reinterpret(),old,v);
+		#elseif (gothreads && java)
+			return iVec.compareAndSet(i,old,v);
+		#elseif (gothreads && cs)
+			return untyped __cs__("System.Threading.Interlocked.CompareExchange(ref {0}[{1}],{2},{3})=={3}",iVec.toData(),i,v,old);
+		#else
+			if(get_int32(i)!=old) 
+				return false;
+			set_int32(i,v);
+			return true;
+		#end
+	}
+	public function add_int32(i:Int,delta:Int):Int { // returns the new value
+		#if (gothreads && java)
+			return iVec.addAndGet(i,delta);
+		#elseif (gothreads && cs)
+			return untyped __cs__("System.Threading.Interlocked.Add(ref {0}[{1}],{2})",iVec.toData(),i,delta);
+		#elseif (gothreads && cpp)
+			var v:Int;
+			do v=get_int32(i) while(!cas_int32(i,v,v+delta)); // only repeats if another thread changed the value
+			return v+delta;
+		#else
+			set_int32(i,get_int32(i)+delta);
+			return get_int32(i);
+		#end
+	}
+	public function load_int32_atomic(i:Int):Int {
+		#if (gothreads && cs)
+			return untyped __cs__("System.Threading.Interlocked.CompareExchange(ref {0}[{1}],0,0)",iVec.toData(),i);
+		#elseif (gothreads && cpp)
+			var v:Int;
+			do v=get_int32(i) while(!cas_int32(i,v,v)); // the exchange is a full memory barrier
+			return v;
+		#else
+			return get_int32(i); // on java, a volatile read of the AtomicIntegerArray
+		#end
+	}
+	public function store_int32_atomic(i:Int,v:Int):Void {
+		#if (gothreads && cs)
+			untyped __cs__("System.Threading.Interlocked.Exchange(ref {0}[{1}],{2})",iVec.toData(),i,v);
+		#elseif (gothreads && cpp)
+			var old:Int;
+			do old=get_int32(i) while(!cas_int32(i,old,v));
+		#else
+			set_int32(i,v); // on java, a volatile write of the AtomicIntegerArray
+		#end
+	}
+	public function cas_int64(i:Int,old:GOint64,v:GOint64):Bool {
+		while(true) {
+			var cur:Dynamic=get(i);
+			if(GOint64.compare(cur==null?GOint64.ofInt(0):cur,old)!=0) 
+				return false;
+			if(swap_if_same(i,cur,v)) 
+				return true;
+		}
+	}
+	public function cas_ref(i:Int,old:Dynamic,v:Dynamic):Bool { // for uintptr and unsafe.Pointer values
+		while(true) {
+			var cur:Dynamic=get(i);
+			if(!(cur==old || (Std.is(cur,Pointer) && Std.is(old,Pointer) && Pointer.isSame(cur,old)))) 
+				return false;
+			if(swap_if_same(i,cur,v)) 
+				return true;
+		}
+	}
+	function swap_if_same(i:Int,cur:Dynamic,v:Dynamic):Bool { // replace the value at offset i by v, if it is still the reference cur
+		#if (gothreads && java)
+			return dVec4.compareAndSet(i>>2,cur,v);
+		#elseif (gothreads && cs)
+			return untyped __cs__("object.ReferenceEquals(System.Threading.Interlocked.CompareExchange(ref {0}[{1}],{2},{3}),{3})",dVec4.toData(),i>>2,v,cur);
+		#elseif (gothreads && cpp)
+			refLock.acquire();
+			var same:Bool=(dVec4[i>>2]==cur);
+			if(same) 
+				dVec4[i>>2]=v;
+			refLock.release();
+			return same;
+		#else
+			set(i,v);
+			return true;
+		#end
+	}
 	private inline static function str(v:Dynamic):String{
 		return v==null?"":Std.string(v);
 	}
@@ -522,12 +662,29 @@ class Pointer {
 	public inline function copy():Pointer {
 		return this;
 	}
-	public inline function isEqual(other:Pointer):Bool{
-		return obj.isEqual(this.off,other.obj,other.off);
+	public inline function sameObject(other:Pointer):Bool{ // do both pointers refer to the same underlying object?
+		return obj==other.obj;
+	}
+	public static function isSame(a:Pointer,b:Pointer):Bool { // Go pointer equality
+		if(a==null || b==null) 
+			return a==b;
+		return (a.obj==b.obj) && (a.off==b.off);
+	}
+	public static function hash(p:Pointer):Int {
+		if(p==null) 
+			return 0;
+		return (p.obj.hashId()*31+p.off) & 0x3FFFFFFF;
 	}
 	public inline function load_object(sz:Int):Object { 
 		return obj.get_object(sz,off);
 	}
+	// the sync/atomic operations, see Object.cas_int32()
+	public inline function cas_int32(old:Int,v:Int):Bool { return obj.cas_int32(off,old,v); }
+	public inline function add_int32(delta:Int):Int { return obj.add_int32(off,delta); }
+	public inline function load_int32_atomic():Int { return obj.load_int32_atomic(off); }
+	public inline function store_int32_atomic(v:Int):Void { obj.store_int32_atomic(off,v); }
+	public inline function cas_int64(old:GOint64,v:GOint64):Bool { return obj.cas_int64(off,old,v); }
+	public inline function cas_ref(old:Dynamic,v:Dynamic):Bool { return obj.cas_ref(off,old,v); }
 	public inline function load():Dynamic {
 		return obj.get(off);
 	}
@@ -612,86 +769,116 @@ class UnsafePointer  {  // Unsafe Pointers are not yet supported, but Go library
 
 @:keep
 class Slice {
-	private var baseArray:Pointer;
+	private var baseArray:Pointer; // the start of the underlying array
 	public var itemSize:Int; // for the size of each item in bytes 
-	private var start:Int;
-	private var end:Int;
-	private var capacity:Int;
+	private var start:Int; // the index in the underlying array of the first item of the slice
 	public var length:Int; // could make this a function access, but it never changes and is used a lot
+	private var capacity:Int; // the number of items from start to the end of the underlying array
 	
 	public function new(fromArray:Pointer, low:Int, high:Int, ularraysz:Int, isz:Int) {
 		baseArray = fromArray;
 		itemSize = isz;
 		if(baseArray==null) {
 			start = 0;
-			end = 0;
+			length = 0;
 			capacity = 0;
 		} else {
-			if( low<0 ) Scheduler.panicFromHaxe( "new Slice() low bound -ve"); 
-			capacity = ularraysz - low; // the capacity of what remains of the array
 			if(high==-1) high = ularraysz; //default upper bound is the capacity of the underlying array
-			if( high > ularraysz ) Scheduler.panicFromHaxe("new Slice() high bound exceeds underlying array length"); 
-			if( low>high ) Scheduler.panicFromHaxe("new Slice() low bound exceeds high bound"); 
+			if( low<0 || low>high || high>ularraysz ) Scheduler.panicFromHaxe("runtime error: slice bounds out of range"); 
 			start = low;
-			end = high;
+			length = high-low;
+			capacity = ularraysz-low; // the capacity of what remains of the array
 		}
-		length = end-start;
 	} 
-	public function subSlice(low:Int, high:Int):Slice {
+	// s[low:high:max], where high and max are -1 if not given, as required by the Go spec: 0 <= low <= high <= max <= cap(s)
+	public function subSlice(low:Int, high:Int, max:Int):Slice {
 		if(high==-1) high = length; //default upper bound is the length of the current slice
-		return new Slice(baseArray,low+start,high+start,capacity+low+start,itemSize);
-	}
+		if(max==-1) max = capacity; //default capacity is the capacity of the current slice
+		if( low<0 || low>high || high>max || max>capacity ) Scheduler.panicFromHaxe("runtime error: slice bounds out of range"); 
+		return new Slice(baseArray,low+start,high+start,max+start,itemSize);
+	}
+	// append the contents of newEnt to the target slice, either of which may be nil (null)
+	public static function appendSlices(target:Slice,newEnt:Slice):Slice{
+		if(newEnt==null || newEnt.len()==0) 
+			return target;
+		if(target==null)
+			target = new Slice(null,0,0,0,newEnt.itemSize);
+		return target.append(newEnt);
+	}
+	// like the Go runtime, items are added in-place if there is spare capacity in the underlying array, 
+	// otherwise the capacity is grown geometrically so that appending in a loop takes amortised constant time per item
 	public function append(newEnt:Slice):Slice{
-		// ignore capacity filling optimization for now TODO
-		var newObj:Object = new Object((length+newEnt.len())*itemSize);
+		if(newEnt==null) 
+			return this;
+		var addLen:Int = newEnt.len();
+		var newLen:Int = length+addLen;
+		if(newLen<=capacity) {
+			if(newEnt.baseArray!=null && newEnt.baseArray.sameObject(baseArray)) { 
+				// the source may overlap the destination, so take a copy of it first (like memmove)
+				var tmp:Array<Object> = new Array<Object>();
+				for(i in 0...addLen)
+					tmp.push(newEnt.itemAddr(i).load_object(itemSize));
+				for(i in 0...addLen)
+					baseArray.addr((start+length+i)*itemSize).store_object(itemSize,tmp[i]);
+			} else {
+				for(i in 0...addLen)
+					baseArray.addr((start+length+i)*itemSize).store_object(itemSize,newEnt.itemAddr(i).load_object(itemSize));
+			}
+			return new Slice(baseArray,start,start+newLen,start+capacity,itemSize);
+		}
+		var newCap:Int = capacity; // grow as in the gc runtime
+		if(newLen > newCap+newCap) {
+			newCap = newLen;
+		} else {
+			if(capacity < 1024) { // gc decides on the old capacity
+				newCap += newCap;
+			} else {
+				while(newCap < newLen) 
+					newCap += newCap>>2;
+			}
+		}
+		var newObj:Object = new Object(newCap*itemSize);
 		for(i in 0...length) 
 			newObj.set_object(itemSize,i*itemSize,this.itemAddr(i).load_object(itemSize));
-		for(i in 0...newEnt.len())
-			newObj.set_object(itemSize,length*itemSize+i*itemSize,newEnt.itemAddr(i).load_object(itemSize));
-		return new Slice(new Pointer(newObj),0,length+newEnt.len(),length+newEnt.len(),itemSize);
+		for(i in 0...addLen)
+			newObj.set_object(itemSize,(length+i)*itemSize,newEnt.itemAddr(i).load_object(itemSize));
+		return new Slice(new Pointer(newObj),0,newLen,newCap,itemSize);
 	}
 	public function copy(source:Slice):Int{
+		if(source==null) 
+			return 0;
 		var copySize:Int=this.len();
 		if(source.len()<this.len()) 
 			copySize=source.len(); 
-		if(this.baseArray==source.baseArray){ // copy within the same slice
-			if(this.start<=source.start){
-				for(i in 0...copySize)
-					this.itemAddr(i).store_object(itemSize,source.itemAddr(i).load_object(itemSize));
-			}else{
-				for(i in copySize...0)
-					this.itemAddr(i).store_object(itemSize,source.itemAddr(i).load_object(itemSize));
-			}
+		if(this.baseArray!=null && source.baseArray!=null && this.baseArray.sameObject(source.baseArray)){ 
+			// copy within the same underlying array, so the source may overlap the destination, take a copy of it first (like memmove)
+			var tmp:Array<Object> = new Array<Object>();
+			for(i in 0...copySize)
+				tmp.push(source.itemAddr(i).load_object(itemSize));
+			for(i in 0...copySize)
+				this.itemAddr(i).store_object(itemSize,tmp[i]);
 		}else{
 			for(i in 0...copySize)
 				this.itemAddr(i).store_object(itemSize,source.itemAddr(i).load_object(itemSize));
 		}
 		return copySize;
 	}
-	//public inline function getAt(idx:Int):Dynamic {
-	//	//if (idx<0 || idx>=(end-start)) Scheduler.panicFromHaxe("Slice index out of range for getAt()");
-	//	return baseArray.addr(idx+start).load();
-	//}
-	//public inline function setAt(idx:Int,v:Dynamic) {
-	//	//if (idx<0 || idx>=(end-start)) Scheduler.panicFromHaxe("Slice index out of range for setAt()");
-	//	baseArray.addr(idx+start).store(v); // this code relies on the object reference passing back
-	//}
 	public inline function len():Int {
 		return length;
 	}
 	public inline function cap():Int {
-		return capacity-start;
+		return capacity;
 	}
 	public inline function itemAddr(idx:Int):Pointer {
-		//if (idx<0 || idx>=(end-start)) Scheduler.panicFromHaxe("Slice index out of range for addr()");
+		//if (idx<0 || idx>=length) Scheduler.panicFromHaxe("Slice index out of range for addr()");
 		return baseArray.addr((idx+start)*itemSize);
 	}
 	public function toString():String {
-		var ret:String = "Slice{"+start+","+end+",[";
+		var ret:String = "Slice{"+start+","+length+","+capacity+",[";
 		if(baseArray!=null) 
-			for(i in 0...(start+capacity) ) {
+			for(i in 0...length) {
 				if(i!=0) ret += ",";
-				ret+=baseArray.addr(i*itemSize).toString(itemSize); // only works for basic types
+				ret+=itemAddr(i).toString(itemSize); // only works for basic types
 			}
 		return ret+"]}";
 	}
@@ -764,23 +951,20 @@ class Interface{ // "interface" is a keyword in PHP but solved using compiler fl
 				return new Interface(t,TypeInfo.zeroValue(t));	 //dummy value as we have hit the panic button
 			}
 	}
-	public static function isEqual(a:Interface,b:Interface):Bool {		// TODO ensure this very wide definition of equality is OK 
+	public static function hash(a:Interface):Int {
 		if(a==null) 
-			if(b==null) return true;
-			else 		return false;
-		if(b==null)		
-			return false;
-		if(! (TypeInfo.isIdentical(a.typ,b.typ)||TypeInfo.isAssignableTo(a.typ,b.typ)||	TypeInfo.isAssignableTo(b.typ,a.typ)) ) 
+			return 0;
+		return TypeInfo.hash(a.typ,a.val);
+	}
+	// Go equality for interface values: the dynamic types must be identical and the values equal,
+	// comparing values of an uncomparable dynamic type panics
+	public static function isEqual(a:Interface,b:Interface):Bool {
+		if(a==null || b==null) 
+			return a==b;
+		if(a.typ!=b.typ) // identical types share the same id
 			return false;
-		else
-			if(a.val==b.val) 
-				return true; // simple equality
-			else // could still be equal underneath a pointer    //TODO is another special case required for Slice?
-				if(Std.is(a.val,Pointer) && Std.is(b.val,Pointer))
-					return a.val.isEqual(b.val);
-				else
-					return false;	
-	}			
+		return TypeInfo.isEqual(a.typ,a.val,b.val);
+	}
 	/* from the SSA documentation:
 	If AssertedType is a concrete type, TypeAssert checks whether the dynamic type in Interface X is equal to it, and if so, 
 		the result of the conversion is a copy of the value in the Interface.
@@ -788,13 +972,17 @@ class Interface{ // "interface" is a keyword in PHP but solved using compiler fl
 		and if so, the result of the conversion is a copy of the Interface value X. If AssertedType is a superInterface of X.Type(), 
 		the operation will fail iff the operand is nil. (Contrast with ChangeInterface, which performs no nil-check.)
 	*/
-	public static function assert(assTyp:Int,ifce:Interface):Dynamic{
+	public static function assert(assTyp:Int,ifce:Interface,fromTyp:Int):Dynamic{ // fromTyp is the static type of ifce, for the message
 		if(ifce==null) {
-			Scheduler.panicFromHaxe( "Interface.assert null Interface");
+			Scheduler.panicFromHaxe("interface conversion: "+TypeInfo.getName(fromTyp)+" is nil, not "+TypeInfo.getName(assTyp));
 			return null;
 		}
 		if(!(TypeInfo.isAssignableTo(ifce.typ,assTyp)||TypeInfo.isIdentical(assTyp,ifce.typ))) { // TODO review need for isIdentical 
-			Scheduler.panicFromHaxe( "type assert failed: expected "+TypeInfo.getName(assTyp)+", got "+TypeInfo.getName(ifce.typ) );
+			if(TypeInfo.isConcrete(assTyp))
+				Scheduler.panicFromHaxe("interface conversion: "+TypeInfo.getName(fromTyp)+" is "+TypeInfo.getName(ifce.typ)+
+					", not "+TypeInfo.getName(assTyp));
+			else
+				Scheduler.panicFromHaxe("interface conversion: "+TypeInfo.getName(ifce.typ)+" is not "+TypeInfo.getName(assTyp));
 			return null;
 		}
 		if(TypeInfo.isConcrete(assTyp))	
@@ -814,7 +1002,7 @@ class Interface{ // "interface" is a keyword in PHP but solved using compiler fl
 	}
 	public static function invoke(ifce:Interface,meth:String,args:Array<Dynamic>):Dynamic {
 		if(ifce==null) 
-			Scheduler.panicFromHaxe( "Interface.invoke null Interface"); 
+			Scheduler.panicFromHaxe("runtime error: invalid memory address or nil pointer dereference"); 
 		//trace("Invoke:"+ifce+":"+meth);
 		if(!Std.is(ifce,Interface)) 
 			Scheduler.panicFromHaxe( "Interface.invoke on non-Interface value"); 
@@ -826,72 +1014,311 @@ class Interface{ // "interface" is a keyword in PHP but solved using compiler fl
 	}
 }
 
-class Channel<T> { //TODO check close & rangeing over a channel
+// GoMap is used for Go maps whose keys are not held as Haxe Int or String values, 
+// it hashes and compares the keys using Go equality, via the generated TypeInfo.hash() and TypeInfo.isEqual() functions
+@:keep
+class GoMap {
+	private var keyType:Int; // the type id of the key
+	private var buckets:Map<Int,Array<GoMapEntry>>;
+	private var count:Int;
+
+	public function new(kt:Int) {
+		keyType = kt;
+		buckets = new Map<Int,Array<GoMapEntry>>();
+		count = 0;
+	}
+	private function find(k:Dynamic):GoMapEntry {
+		var b:Array<GoMapEntry> = buckets.get(TypeInfo.hash(keyType,k));
+		if(b!=null)
+			for(e in b)
+				if(TypeInfo.isEqual(keyType,e.k,k))
+					return e;
+		return null;
+	}
+	public function exists(k:Dynamic):Bool {
+		return find(k)!=null;
+	}
+	public function get(k:Dynamic):Dynamic {
+		var e:GoMapEntry = find(k);
+		return e==null ? null : e.v;
+	}
+	public function set(k:Dynamic,v:Dynamic):Void {
+		var h:Int = TypeInfo.hash(keyType,k);
+		var b:Array<GoMapEntry> = buckets.get(h);
+		if(b==null) {
+			b = new Array<GoMapEntry>();
+			buckets.set(h,b);
+		}
+		for(e in b)
+			if(TypeInfo.isEqual(keyType,e.k,k)) {
+				e.v = v;
+				return;
+			}
+		if(Std.is(k,Object)) 
+			k = cast(k,Object).copy(); // keys have value semantics
+		b.push(new GoMapEntry(k,v));
+		count++;
+	}
+	public function remove(k:Dynamic):Bool {
+		var h:Int = TypeInfo.hash(keyType,k);
+		var b:Array<GoMapEntry> = buckets.get(h);
+		if(b!=null)
+			for(i in 0...b.length)
+				if(TypeInfo.isEqual(keyType,b[i].k,k)) {
+					b[i].removed = true; // skipped by any range in progress
+					b.splice(i,1);
+					if(b.length==0) 
+						buckets.remove(h);
+					count--;
+					return true;
+				}
+		return false;
+	}
+	public function keys():Iterator<Dynamic> {
+		var ret = new Array<Dynamic>();
+		for(b in buckets)
+			for(e in b)
+				ret.push(e.k);
+		return ret.iterator();
+	}
+	public function entries():Iterator<GoMapEntry> { // used by range statements, which must not look keys up again as NaN never matches
+		var ret = new Array<GoMapEntry>();
+		for(b in buckets)
+			for(e in b)
+				ret.push(e);
+		return ret.iterator();
+	}
+	public function iterator():Iterator<Dynamic> {
+		var ret = new Array<Dynamic>();
+		for(b in buckets)
+			for(e in b)
+				ret.push(e.v);
+		return ret.iterator();
+	}
+	public inline function len():Int {
+		return count;
+	}
+
+	// keySnapshot takes a copy of the keys of a map at the start of a range statement, so that the map may be changed in the loop;
+	// compile with -D maprandom to start each range at a random key, so that code which relies on the order fails in testing
+	public static function keySnapshot<K>(it:Iterator<K>):Iterator<K> {
+		var ret = new Array<K>();
+		for(k in it)
+			ret.push(k);
+		#if maprandom
+			if(ret.length>1) {
+				var s:Int = Std.random(ret.length);
+				ret = ret.slice(s).concat(ret.slice(0,s));
+			}
+		#end
+		return ret.iterator();
+	}
+
+	// hash functions for the values held as Haxe types, the results are kept to 30 bits to suit all targets
+	public static function hashString(s:String):Int {
+		var h:Int = 0;
+		for(i in 0...s.length) 
+			h = ((h<<5)-h+StringTools.fastCodeAt(s,i)) & 0x3FFFFFFF;
+		return h;
+	}
+	public static function hashFloat(f:Float):Int {
+		if(f==0 || Math.isNaN(f)) // +0 and -0 are equal, NaN never equals anything so can be anywhere
+			return 0;
+		if(f==Math.ffloor(f) && f>=-1073741824.0 && f<=1073741823.0)
+			return Std.int(f) & 0x3FFFFFFF;
+		return hashString(Std.string(f));
+	}
+	public static function hashInt64(v:GOint64):Int {
+		return (GOint64.toInt(v) ^ GOint64.toInt(GOint64.ushr(v,32))) & 0x3FFFFFFF;
+	}
+	public static function hashComplex(c:Complex):Int {
+		return (hashFloat(c.real)*31 + hashFloat(c.imag)) & 0x3FFFFFFF;
+	}
+	public static function hashDynamic(v:Dynamic):Int { // for uintptr
+		if(v==null) 
+			return 0;
+		if(Std.is(v,Int)) 
+			return cast(v,Int) & 0x3FFFFFFF;
+		if(Std.is(v,Pointer)) 
+			return Pointer.hash(v);
+		return 0;
+	}
+}
+
+@:keep
+class GoMapEntry {
+	public var k:Dynamic;
+	public var v:Dynamic;
+	public var removed:Bool = false; // set when the entry is deleted from its map
+	public function new(key:Dynamic,val:Dynamic) {
+		k = key;
+		v = val;
+	}
+}
+
+class Channel<T> { // the operations used by the generated code are static, so that they follow the Go spec for nil channels
 var entries:Array<T>;
 var max_entries:Int;
 var num_entries:Int;
 var oldest_entry:Int;	
 var closed:Bool;
+var unbuffered:Bool; // unbuffered channels hand each value from a waiting sender to a waiting receiver
+var senders:List<{gr:Int,val:T,to:Int}>; // for unbuffered channels, the senders waiting for their value to be received, oldest first,
+	// with the goroutine a select has handed each value to, or noReceiver if any receiver may take it
+var receivers:Array<Int>; // for unbuffered channels, the goroutines waiting to receive
+static inline var noReceiver:Int=-1;
 
 public function new(how_many_entries:Int) {
-	if(how_many_entries<=0)
+	unbuffered = how_many_entries<=0;
+	if(unbuffered) {
 		how_many_entries=1;
+		senders = new List<{gr:Int,val:T,to:Int}>();
+		receivers = new Array<Int>();
+	}
 	entries = new Array<T>();
 	max_entries = how_many_entries;
 	oldest_entry = 0;
 	num_entries = 0;
 	closed = false;
+	uid = 0;
+}
+var uid:Int; // a unique identifier for the channel, only allocated if required for hashing
+static var nextUid:Int = 0;
+public function hashId():Int {
+	if(uid==0) {
+		nextUid = (nextUid+1) & 0x3FFFFFFF;
+		if(nextUid==0) nextUid = 1;
+		uid = nextUid;
+	}
+	return uid;
+}
+public static function waitReason<T>(c:Channel<T>,reason:String):String { // for the goroutine dump when deadlocked
+	return c==null ? reason+" (nil chan)" : reason;
+}
+public static function hasSpace<T>(c:Channel<T>,gr:Int):Bool { // used by select
+	if(c==null) return false; // spec: "A nil channel is never ready for communication."
+	if(c.closed) return true; // so that select chooses the case, which then panics
+	if(c.unbuffered) return freeReceiver(c,gr)!=noReceiver;
+	return c.num_entries < c.max_entries;
+}
+static function freeReceiver<T>(c:Channel<T>,gr:Int):Int { // a waiting receiver, other than gr, that no select has yet handed a value to
+	for(r in c.receivers)
+		if(r!=gr && !Scheduler.isReceiving(r))
+			return r;
+	return noReceiver;
+}
+public static function canSend<T>(c:Channel<T>):Bool { // used by channel send, which waits on an unbuffered channel after sending
+	if(c!=null && c.unbuffered) return true; 
+	return hasSpace(c,noReceiver);
+}
+public static function send<T>(c:Channel<T>,source:T,gr:Int) {
+	sendTo(c,source,gr,noReceiver);
+}
+public static function selectSend<T>(c:Channel<T>,source:T,gr:Int) { // after hasSpace(), hands the value to a waiting receiver
+	sendTo(c,source,gr,(c.unbuffered && !c.closed) ? freeReceiver(c,gr) : noReceiver);
+}
+static function sendTo<T>(c:Channel<T>,source:T,gr:Int,to:Int) {
+	if(c.closed) Scheduler.panicFromHaxe("send on closed channel"); 
+	if(c.unbuffered) {
+		c.senders.add({gr:gr,val:source,to:to});
+		Scheduler.sending(gr,true); // the sender now waits until its value has been received
+		if(to!=noReceiver) {
+			c.receivers.remove(to); // so that no other select sends to it
+			Scheduler.receiving(to,true); // the receiver must now take the value, even from a select
+		}
+	} else {
+		c.entries[(c.oldest_entry + c.num_entries) % c.max_entries]=source;  
+		c.num_entries++;
+	}
+	Scheduler.progress();
 }
-public function hasSpace():Bool {
-	if(this==null) return false; // non-existant channels never have space
-	if(closed) return false; // closed channels don't have space
-	return num_entries < max_entries;
-}
-public function send(source:T):Bool {
-	if(closed) Scheduler.panicFromHaxe( "attempt to send to closed channel"); 
-	var next_element:Int;
-	if (this.hasSpace()) {
-		next_element = (oldest_entry + num_entries) % max_entries;
-		num_entries++;
-		entries[next_element]=source;  
+public static function hasNoContents<T>(c:Channel<T>,gr:Int):Bool { // used by channel read
+	if(c==null) return true; // spec: "Receiving from a nil channel blocks forever."
+	if(c.closed) return false; // spec: "Receiving from a closed channel always succeeds..."
+	if(c.unbuffered) {
+		if(senderFor(c,gr)!=null) return false;
+		recvWait(c,gr);
 		return true;
-	} else
-		return false;
+	}
+	return c.num_entries == 0;
+}
+public static function hasContents<T>(c:Channel<T>,gr:Int):Bool { // used by select
+	if(c==null) return false; // spec: "Receiving from a nil channel blocks forever."
+	if(c.closed) return true; // spec: "Receiving from a closed channel always succeeds..."
+	if(c.unbuffered) return senderFor(c,gr)!=null;
+	return c.num_entries != 0;
+}
+public static function handedTo<T>(c:Channel<T>,gr:Int):Bool { // used by select, which must receive a value a select has handed to it
+	if(c==null || !c.unbuffered || !Scheduler.isReceiving(gr)) return false;
+	for(s in c.senders)
+		if(s.to==gr)
+			return true;
+	return false;
+}
+static function senderFor<T>(c:Channel<T>,gr:Int):{gr:Int,val:T,to:Int} { // the sender whose value gr should receive, or null
+	var ret:{gr:Int,val:T,to:Int}=null;
+	for(s in c.senders)
+		if(s.to==gr)
+			return s; // handed to gr by a select
+		else if(ret==null && s.to==noReceiver)
+			ret=s;
+	return ret;
 }
-public function hasNoContents():Bool { // used by channel read
-	if (this==null) return true; // spec: "Receiving from a nil channel blocks forever."
-	if (closed) return false; // spec: "Receiving from a closed channel always succeeds..."
-	else return num_entries == 0;
-}
-public function hasContents():Bool { // used by select
-	if (this==null) return false; // spec: "Receiving from a nil channel blocks forever."
-	if (closed) return true; // spec: "Receiving from a closed channel always succeeds..."
-	return num_entries != 0;
-}
-public function receive(zero:T):{r0:T ,r1:Bool} {
-	var ret:T=zero;
-	if (num_entries > 0) {
-		ret=entries[oldest_entry];
-		oldest_entry = (oldest_entry + 1) % max_entries;
-		num_entries--;
+public static function recvWait<T>(c:Channel<T>,gr:Int) { // the goroutine is waiting to receive, so a select may send to it 
+	if(c!=null && c.unbuffered && c.receivers.indexOf(gr)==-1) 
+		c.receivers.push(gr);
+}
+public static function recvDone<T>(c:Channel<T>,gr:Int) { // the goroutine is no longer waiting to receive
+	if(c!=null && c.unbuffered) 
+		c.receivers.remove(gr);
+}
+public static function receive<T>(c:Channel<T>,zero:T,gr:Int):{r0:T ,r1:Bool} {
+	if(c.unbuffered) {
+		recvDone(c,gr);
+		var s=senderFor(c,gr);
+		if(s!=null) {
+			c.senders.remove(s);
+			Scheduler.sending(s.gr,false);
+			if(s.to!=noReceiver)
+				Scheduler.receiving(gr,false);
+			Scheduler.progress();
+			return {r0:s.val,r1:true};
+		}
+	} else if(c.num_entries > 0) {
+		var ret:T=c.entries[c.oldest_entry];
+		c.oldest_entry = (c.oldest_entry + 1) % c.max_entries;
+		c.num_entries--;
+		Scheduler.progress();
 		return {r0:ret,r1:true};
-	} else
-		if(closed)
-			return {r0:ret,r1:false}; // spec: "Receiving from a closed channel always succeeds, immediately returning the element type's zero value."
-		else {
-			Scheduler.panicFromHaxe( "channel receive unreachable code!"); 
-			return {r0:ret,r1:false}; //dummy value as we have hit the panic button
+	}
+	if(!c.closed) 
+		Scheduler.fatal("Scheduler: channel receive from an empty channel\n"+Scheduler.stackDump()); // the caller should have waited
+	return {r0:zero,r1:false}; // spec: "Receiving from a closed channel always succeeds, immediately returning the element type's zero value."
+}
+public static function len<T>(c:Channel<T>):Int { 
+	if(c==null || c.unbuffered) return 0;
+	return c.num_entries; 
+}
+public static function cap<T>(c:Channel<T>):Int { 
+	if(c==null || c.unbuffered) return 0;
+	return c.max_entries; 
+}
+public static function close<T>(c:Channel<T>) {
+	if(c==null) Scheduler.panicFromHaxe("close of nil channel"); 
+	Scheduler.lock();
+	if(c.closed) Scheduler.panicFromHaxe("close of closed channel"); 
+	c.closed = true;
+	if(c.unbuffered) { // the waiting senders panic, rather than their values being received
+		for(s in c.senders) {
+			Scheduler.sendOnClosed(s.gr);
+			if(s.to!=noReceiver)
+				Scheduler.receiving(s.to,false);
 		}
-}
-public inline function len():Int { 
-	return num_entries; 
-}
-public inline function cap():Int { 
-	return max_entries; 
-}
-public inline function close() {
-	if(this==null) Scheduler.panicFromHaxe( "attempt to close a nil channel" ); 
-	closed = true;
+		c.senders.clear();
+		c.receivers=new Array<Int>();
+	}
+	Scheduler.progress();
+	Scheduler.unlock();
 }
 }
 
@@ -1071,7 +1498,7 @@ public static inline function and(x:HaxeInt64abs,y:HaxeInt64abs):HaxeInt64abs {
 }
 private static function checkDiv(x:HaxeInt64abs,y:HaxeInt64abs,isSigned:Bool):HaxeInt64abs {
 	if(HaxeInt64Typedef.isZero(y))
-		Scheduler.panicFromHaxe( "attempt to divide 64-bit value by 0"); 
+		Scheduler.panicFromHaxe("runtime error: integer divide by zero"); 
 	if(isSigned && (HaxeInt64Typedef.compare(y,HaxeInt64Typedef.ofInt(-1))==0) && (HaxeInt64Typedef.compare(x,HaxeInt64Typedef.make(0x80000000,0))==0) ) 
 	{
 		//trace("checkDiv 64-bit special case");
@@ -1501,16 +1928,19 @@ public function setPH(ph:Int){
 
 public inline function defer(fn:StackFrame){
 	//trace("defer");
-	_deferStack.add(fn); // add to the end of the list, so that runDefers() get them in the right order
+	_deferStack.push(fn); // add to the start of the list, so that the deferred functions are run last-in-first-out
 }
 
-public function runDefers(){
+public function runDefers():Bool { // run the most recently deferred function, if there is one, returning true if there was
 	//trace("runDefers");
-	while(!_deferStack.isEmpty()){
-		//trace("runDefers-pop");
-		Scheduler.push(_goroutine,_deferStack.pop());
-		//Scheduler.traceStackDump();
-	}
+	if(_deferStack.isEmpty()) 
+		return false;
+	Scheduler.push(_goroutine,_deferStack.pop());
+	return true;
+}
+
+public function resumeRecover():Void { // overridden by functions with a recover block, to resume there after a recovered panic
+	Scheduler.fatal("Scheduler: no recover block to resume in "+_functionName);
 }
 
 
@@ -1528,124 +1958,751 @@ public var _bds:Dynamic; // bindings for closures as a anonymous struct
 public var _deferStack:List<StackFrame>;
 function run():StackFrame; // function state machine (set up by each Go function Haxe class)
 function res():Dynamic; // function result (set up by each Go function Haxe class)
+function resumeRecover():Void; // set the state machine to resume at the recover block (set up by Go functions with defers)
+}
+
+class PanicRecord { // a panic in progress on a goroutine
+public var val:Interface; // the value passed to panic()
+public var frame:StackFrame=null; // the stack frame whose deferred functions are being run
+public var def:StackFrame=null; // the deferred function being run, only it may recover() the panic
+public var recovered:Bool=false;
+public var goexit:Bool=false; // runtime.Goexit() unwinds the stack in the same way, but cannot be recovered
+public function new(v:Interface){
+	val=v;
+}
+}
+
+class GoPanic { // an unrecovered Go panic, as thrown to the Haxe code that called Go
+public var value(default,null):Interface; // the value passed to panic()
+public var message(default,null):String; // as printed by gc, for example "panic: runtime error: index out of range"
+public var traceback(default,null):String; // the Go stack when the panic began
+public var goroutine(default,null):Int; // the goroutine that panicked
+public function new(v:Interface,m:String,t:String,gr:Int){
+	value=v;
+	message=m;
+	traceback=t;
+	goroutine=gr;
+}
+public function toString():String {
+	return message+"\n\n"+traceback;
+}
+}
+
+class GoFuture<T> { // the result of a Go function called from Haxe using callAsync()
+public var done(default,null):Bool=false;
+public var result(default,null):T;
+public var panic(default,null):GoPanic=null; // set instead of the result if the Go function panicked
+var callbacks:Array<T->Void>;
+var panicCallbacks:Array<GoPanic->Void>;
+public function new() {
+	callbacks=new Array<T->Void>();
+	panicCallbacks=new Array<GoPanic->Void>();
+}
+public function then(cb:T->Void,?onPanic:GoPanic->Void):GoFuture<T> { // cb is called with the result when the Go function returns, onPanic if it panics
+	if(done) {
+		if(panic==null) 
+			cb(result);
+		else if(onPanic!=null) 
+			onPanic(panic);
+	} else {
+		callbacks.push(cb);
+		if(onPanic!=null) 
+			panicCallbacks.push(onPanic);
+	}
+	return this;
+}
+public function complete(r:T) {
+	done=true;
+	result=r;
+	for(cb in callbacks) 
+		cb(r);
+	callbacks=null;
+	panicCallbacks=null;
+}
+public function fail(p:GoPanic) {
+	done=true;
+	panic=p;
+	var handlers:Array<GoPanic->Void>=panicCallbacks;
+	callbacks=null;
+	panicCallbacks=null;
+	if(handlers.length==0) 
+		throw p; // as with gc, a panic that nothing handles ends the program, as it would from callFromHaxe()
+	for(cb in handlers) 
+		cb(p);
+}
+}
+
+typedef Watched = {sf:StackFrame,fn:Void->Void,onPanic:GoPanic->Void,panic:GoPanic}; // see Scheduler.whenComplete()
+
+#if (gothreads && (cpp || java || cs))
+typedef GoMutex = #if cpp cpp.vm.Mutex #elseif java java.vm.Mutex #else cs.vm.Mutex #end ;
+typedef GoThread = #if cpp cpp.vm.Thread #elseif java java.vm.Thread #else cs.vm.Thread #end ;
+typedef GoLock = #if cpp cpp.vm.Lock #elseif java java.vm.Lock #else cs.vm.Lock #end ;
+#if !cs typedef GoTls<T> = #if cpp cpp.vm.Tls<T> #else java.vm.Tls<T> #end ; #end
+class GoThreadState { // the scheduler state of each thread that runs goroutines
+public var gr:Int=-1; // the goroutine the thread is running, if any
+public var locks:Int=0; // the number of times the thread holds the scheduler lock
+public var fatalError:Dynamic=null; // see Scheduler.fatal()
+public function new(){}
 }
+#end
 
-class Scheduler { // NOTE this code requires a single-thread, as there is no locking 
+class Scheduler { // NOTE locking is only required when goroutines run on several threads (-D gothreads), see lock()
 // public
 public static var doneInit:Bool=false; // flag to limit go-routines to 1 during the init() processing phase
+public static var progressCount:Int=0; // incremented by progress() on any change of channel state, which may allow a waiting goroutine to run
+public static var idling:Bool=false; // set when the last run through all the goroutines found that they were all waiting
 // private
 static var grStacks:Array<List<StackFrame>>=new Array<List<StackFrame>>(); 
-static var grInPanic:Array<Bool>=new Array<Bool>();
-static var grPanicMsg:Array<Interface>=new Array<Interface>();
-static var panicStackDump:String="";
-static var entryCount:Int=0; // this to be able to monitor the re-entrys into this routine for debug
-static var currentGR:Int=0; // the current goroutine, used by Scheduler.panicFromHaxe(), NOTE this requires a single thread
+static var grPanics:Array<List<PanicRecord>>=new Array<List<PanicRecord>>(); // the panics in progress, most recent first
+static var grWaiting:Array<String>=new Array<String>(); // why each goroutine could not continue when it last ran, or null if it can
+static var grSending:Array<Int>=new Array<Int>(); // is each goroutine waiting for the value it sent on an unbuffered channel to be received?
+static inline var notSending:Int=0;
+static inline var isSendingValue:Int=1;
+static inline var sentOnClosed:Int=2; // the channel was closed while the goroutine waited
+static var grReceiving:Array<Bool>=new Array<Bool>(); // has a select handed each goroutine a value on an unbuffered channel, which it must receive?
+static var grAsleep:Array<Bool>=new Array<Bool>(); // is each goroutine parked until a timer wakes it?
+static var grInRun:Array<Bool>=new Array<Bool>(); // is each goroutine running, perhaps part way through a call to Haxe code?
+static var grCallingHaxe:Array<Bool>=new Array<Bool>(); // is each goroutine part way through a call to Haxe code?
+static var grNew:Array<Bool>=new Array<Bool>(); // has each goroutine number been allocated, but its first stack frame not yet pushed?
+static var grSeen:Array<Int>=new Array<Int>(); // the progressCount when each goroutine last started to run
+static var grPreempt:Array<Int>=new Array<Int>(); // the loop iterations each goroutine has left before it is preempted, see preempt()
+static var hostCalls:List<Int>=new List<Int>(); // the goroutines of the calls from Haxe in progress, innermost first
+static var timers:Array<{when:Float,gr:Int}>=new Array<{when:Float,gr:Int}>(); // a binary heap of the sleeping goroutines, earliest first
+public static var hostDriven:Bool=false; // is the scheduler being run from host timer or frame events, or by Go.pump()?
+static var hostTimerSet:Bool=false; // has a host timer event been requested to wake the earliest sleeping goroutine?
+static var grPanicDump:Array<String>=new Array<String>(); // the stack dump of the first panic in progress on each goroutine
+static var entryCount:Int=0; // the depth of Haxe->Go->Haxe->Go calls
+static var currentGR(get,set):Int; // the goroutine this thread is running, used by Scheduler.panicFromHaxe(), or -1 if none
+static var mainGR:Int=-1; // the goroutine running the Go main function, once it has started
+static var mainGoexit:Bool=false; // set when the main goroutine has called runtime.Goexit()
+static var watched:Array<Watched>=new Array<Watched>(); // see whenComplete()
 
 public static function timerEventHandler(dummy:Dynamic) { // if the scheduler is being run from a timer, this is where it comes to
+	hostDriven=true;
 	runAll();
 }
 
-public static function runAll() { // this must be re-entrant, in order to allow Haxe->Go->Haxe->Go for some runtime functions
-	var cg:Int=0; // reentrant current goroutine
+public static function runAll() { // this is re-entrant, to allow Haxe->Go->Haxe->Go calls to any depth
+	lock();
 	entryCount++;
-	if(entryCount>2) { // this is the simple limit to runtime recursion  
-		throw "Scheduler.runAll() entryCount exceeded - "+stackDump();
-	}
-
-	// special handling for goroutine 0, which is used in the initialisation phase and re-entrantly, where only one goroutine may operate		
-	if(grStacks[0].isEmpty()) { // check if there is ever likley to be anything to do
-		if(grStacks.length<=1) { 
-			throw "Scheduler: there is only one goroutine and its stack is empty\n"+stackDump();		
-		}
-	} else { // run goroutine zero
-		runOne(0,entryCount);
-	}
-
-	if(doneInit  && entryCount==1 ) {	// don't run extra goroutines when we are re-entrant or have not finished initialistion
-									// NOTE this means that Haxe->Go->Haxe->Go code cannot run goroutines 
-		for(cg in 1...grStacks.length) { // length may grow during a run through, NOTE goroutine 0 not run again
-			if(!grStacks[cg].isEmpty()) {
-				runOne(cg,entryCount);
-			}
-		}
+	idling=false;
+	wakeTimers();
+	unlock();
+	try {
+		runPass();
+	} catch(e:Dynamic) { // the Haxe code that called Go may catch an unrecovered panic, then call Go again
+		lock();
+		entryCount--;
+		unlock();
+		throw e;
+	}
+	lock();
+	entryCount--;
+	if(entryCount>0) // the goroutines that ran before this nested call cannot know what it changed, so must run again
+		progress();
+	var complete:Bool=entryCount==0 && watched.length>0;
+	unlock();
+	if(complete)
+		completeWatched();
+}
+static function runPass() { // run each goroutine that can run, until it next gives up control
+	lock();
+	var nothingToDo:Bool=grStacks[0].isEmpty() && grStacks.length<=1;
+	unlock();
+
+	if(nothingToDo) { // check if there is ever likley to be anything to do
+		if(mainGoexit)
+			fatal("fatal error: no goroutines (main called runtime.Goexit) - deadlock!");
+		fatal("Scheduler: there is only one goroutine and its stack is empty\n"+stackDump());
+	}
+
+	if(!doneInit) { // during initialisation only the goroutine of the innermost call from Haxe runs
+		lock();
+		var gr:Int=hostCalls.isEmpty() ? 0 : hostCalls.first();
+		unlock();
+		if(claim(gr))
+			runOne(gr);
+	} else {
+		startThreads();
+		for(cg in 0...grStacks.length) // length may grow during a run through
+			if(claim(cg))
+				runOne(cg);
+		lock();
 		// prune the list of goroutines only at the end (goroutine numbers are in the stack frames, so can't be altered) 
 		while(grStacks.length>1){
-			if(grStacks[grStacks.length-1].isEmpty())
+			if(grStacks[grStacks.length-1].isEmpty() && !grInRun[grStacks.length-1] && !grNew[grStacks.length-1])
 				grStacks.pop();
 			else
 				break;
 		}
+		var asleep:Bool=allWaiting();
+		var hostCanCall:Bool=hostDriven && hostCalls.isEmpty(); // the host gets control back, so may yet call Go code that lets the goroutines continue
+		unlock();
+		threadFailed();
+		if(asleep) {
+			idling=true;
+			if(timers.length>0)
+				idle();
+			else if(!hostCanCall)
+				deadlock();
+		}
 	}
-	entryCount--;
 }
-static inline function runOne(gr:Int,entryCount:Int){ // called from above to call individual goroutines TODO: Review for multi-threading
-	if(grInPanic[gr]) {
-		if(entryCount!=1) { // we are in re-entrant code, so we can't panic again, as this may be part of the panic handling...
-				// NOTE this means that Haxe->Go->Haxe->Go code cannot use panic() reliably 
-				run1(gr);
-		} else {
-			while(grInPanic[gr]){
-				if(grStacks[gr].isEmpty())
-					throw "Panic in goroutine "+gr+"\n"+panicStackDump; // use stored stack dump
-				else {
-					var sf:StackFrame=grStacks[gr].pop();
-					while(!sf._deferStack.isEmpty()){ 
-						// NOTE this will run all of the defered code for a function, even if recover() is encountered
-						// TODO go back to recover code block in SSA function struct after a recover
-						var def:StackFrame=sf._deferStack.pop();
-						Scheduler.push(gr,def);
-						while(def._incomplete) 
-							runAll(); // with entryCount >1, so run as above 
-					}
-				}
+static inline function runnable(gr:Int):Bool { // goroutines that are part way through a call to Haxe cannot run again until it returns
+	return !grStacks[gr].isEmpty() && !grAsleep[gr] && !grInRun[gr];
+}
+static function claim(gr:Int):Bool { // mark a runnable goroutine as running, so that no other thread runs it at the same time
+	lock();
+	var ok:Bool=gr<grStacks.length && runnable(gr);
+	if(ok) {
+		grWaiting[gr]=null;
+		grInRun[gr]=true;
+		grSeen[gr]=progressCount;
+	}
+	unlock();
+	return ok;
+}
+public static function runFromHaxe(sf:StackFrame) { // run the scheduler until the function called from Haxe returns
+	var caller:Int=currentGR; // the goroutine whose code called Haxe, if any
+	lock();
+	if(caller>=0)
+		grCallingHaxe[caller]=true;
+	hostCalls.push(sf._goroutine);
+	unlock();
+	try {
+		while(sf._incomplete) 
+			runAll();
+	} catch(e:Dynamic) { // a GoPanic, or a fatal error
+		hostCallDone(sf._goroutine,caller);
+		if(Std.is(e,GoPanic) && cast(e,GoPanic).goroutine!=sf._goroutine) // as with gc, a panic in any other goroutine ends the program
+			fatal(Std.string(e));
+		throw e;
+	}
+	hostCallDone(sf._goroutine,caller);
+}
+static function hostCallDone(gr:Int,caller:Int) {
+	lock();
+	hostCalls.remove(gr);
+	if(caller>=0)
+		grCallingHaxe[caller]=false;
+	unlock();
+}
+public static function whenComplete(sf:StackFrame,fn:Void->Void,?onPanic:GoPanic->Void) { // call fn after the function of stack frame sf has returned, or onPanic if it panicked
+	lock();
+	watched.push({sf:sf,fn:fn,onPanic:onPanic,panic:null});
+	unlock();
+}
+public static inline function watchCount():Int {
+	return watched.length;
+}
+static function completeWatched() { // called outside the scheduler, so that the functions may call Go code
+	lock();
+	var done=watched.filter(function(w) return !w.sf._incomplete || w.panic!=null);
+	if(done.length>0)
+		watched=watched.filter(function(w) return w.sf._incomplete && w.panic==null);
+	unlock();
+	for(w in done) 
+		if(w.panic==null)
+			w.fn();
+		else
+			w.onPanic(w.panic);
+}
+static function watchedPanic(gr:Int,p:GoPanic):Bool { // give the unrecovered panic of goroutine gr to its watcher, if it has one that takes panics
+	lock();
+	var found:Bool=false;
+	for(w in watched)
+		if(w.onPanic!=null && w.panic==null && w.sf._incomplete && w.sf._goroutine==gr) {
+			w.panic=p; // passed on by completeWatched()
+			found=true;
+			break;
+		}
+	unlock();
+	return found;
+}
+// allWaiting is true when every goroutine waited the last time it ran, and no channel state has changed since it started that run, 
+// so none of them can continue until a timer or host event
+static function allWaiting():Bool {
+	var waiting:Int=0;
+	for(gr in 0...grStacks.length)
+		if(!grStacks[gr].isEmpty()) {
+			if(!grCallingHaxe[gr] && !grAsleep[gr]) // goroutines part way through a call to Haxe are waiting for it to return
+				if(grInRun[gr] || grWaiting[gr]==null || grSeen[gr]!=progressCount)
+					return false;
+			waiting++;
+		}
+	return waiting>0;
+}
+static function deadlock() { // as with gc, the runtime.Goexit message in runPass() is only given when no goroutines remain
+	fatal("fatal error: all goroutines are asleep - deadlock!\n"+waitDump());
+}
+static function waitDump():String { // the state of each waiting goroutine, in the form gc uses
+	var ret:String="";
+	for(gr in 0...grStacks.length)
+		if(!grStacks[gr].isEmpty()) {
+			ret += "\ngoroutine "+gr+" ["+(grCallingHaxe[gr] ? "calling Haxe" : grWaiting[gr])+"]:\n";
+			ret += traceback(gr);
+		}
+	return ret;
+}
+static inline var tracebackLimit:Int=100; // as with gc, the number of frames shown before the rest are elided
+static function traceback(gr:Int):String { // the frames of a goroutine, most recent first, in the form gc uses
+	var ret:String="";
+	var n:Int=0;
+	for(sf in grStacks[gr]) {
+		if(n++==tracebackLimit) {
+			ret += "...additional frames elided...\n";
+			break;
+		}
+		ret += sf._functionName+"()\n\t"+Go.CPos(sf._latestPH)+"\n";
+	}
+	return ret;
+}
+public static var preemptive:Bool=false; // set when compiled with the -preempt flag, so that Go code can tell
+static inline var preemptBudget:Int=1000; // the number of loop iterations of a goroutine between preemptions, when using the -preempt flag
+public static function preempt(gr:Int):Bool { // called at the end of each loop iteration, true when the goroutine should give up control
+	lock(); // makeGoroutine() may be growing grPreempt
+	var due:Bool=--grPreempt[gr]<=0;
+	if(due)
+		grPreempt[gr]=preemptBudget;
+	unlock();
+	return due;
+}
+public static inline function sending(gr:Int,isSending:Bool) {
+	grSending[gr]=isSending ? isSendingValue : notSending;
+}
+public static inline function receiving(gr:Int,isReceiving:Bool) {
+	grReceiving[gr]=isReceiving;
+}
+public static inline function isReceiving(gr:Int):Bool {
+	return grReceiving[gr];
+}
+public static function sendOnClosed(gr:Int) {
+	grSending[gr]=sentOnClosed;
+}
+public static function isSending(gr:Int):Bool {
+	lock();
+	var s:Int=grSending[gr];
+	if(s==sentOnClosed)
+		grSending[gr]=notSending;
+	unlock();
+	if(s==sentOnClosed)
+		panicFromHaxe("send on closed channel");
+	return s==isSendingValue;
+}
+public static function timeNow():Float { // in seconds since 1970
+	#if sys
+		return Sys.time();
+	#else
+		return Date.now().getTime()/1000.0;
+	#end
+}
+public static function sleepUntil(gr:Int,when:Float) { // park the goroutine until the time given by timeNow()
+	if(gr>=grStacks.length||gr<0)
+		fatal("Scheduler.sleepUntil() invalid goroutine");
+	lock();
+	grAsleep[gr]=true;
+	grWaiting[gr]="sleep";
+	timers.push({when:when,gr:gr});
+	siftUp(timers.length-1);
+	unlock();
+}
+public static function wakeEarly(gr:Int) { // wake a sleeping goroutine before its time, used when a timer is stopped
+	lock();
+	for(i in 0...timers.length)
+		if(timers[i].gr==gr) { // woken by wakeTimers()
+			timers[i].when=Math.NEGATIVE_INFINITY;
+			siftUp(i);
+			break;
+		}
+	unlock();
+}
+static function siftUp(i:Int) { // restore the order of the timers heap after timers[i] has been added or made earlier
+	while(i>0) {
+		var parent:Int=(i-1)>>1;
+		if(timers[parent].when<=timers[i].when) 
+			break;
+		var t=timers[parent]; timers[parent]=timers[i]; timers[i]=t;
+		i=parent;
+	}
+}
+static function wakeTimers() { // wake the goroutines whose time has come
+	if(timers.length==0) 
+		return;
+	var now:Float=timeNow();
+	while(timers.length>0 && timers[0].when<=now) {
+		grAsleep[timers[0].gr]=false;
+		progress();
+		var last=timers.pop();
+		if(timers.length==0) 
+			break;
+		timers[0]=last;
+		var i:Int=0;
+		while(true) { // sift down
+			var least:Int=i;
+			var l:Int=2*i+1;
+			if(l<timers.length && timers[l].when<timers[least].when) least=l;
+			if(l+1<timers.length && timers[l+1].when<timers[least].when) least=l+1;
+			if(least==i) 
+				break;
+			var t=timers[least]; timers[least]=timers[i]; timers[i]=t;
+			i=least;
+		}
+	}
+}
+static function idle() { // every goroutine is waiting, and the earliest timer has not yet expired
+	lock();
+	var wait:Float=timers.length==0 ? 0 : timers[0].when-timeNow();
+	unlock();
+	if(wait<=0) 
+		return;
+	#if sys
+		Sys.sleep(wait);
+	#elseif (js || flash)
+		if(hostDriven && !hostTimerSet) { // give control back to the host until the timer is due
+			hostTimerSet=true;
+			haxe.Timer.delay(function(){hostTimerSet=false; timerEventHandler(null);},Math.ceil(wait*1000.0));
+		}
+	#end
+}
+public static function progress() { // called on any change that may allow a waiting goroutine to run
+	lock();
+	progressCount++;
+	wakeWorkers();
+	unlock();
+}
+public static inline function wait(gr:Int,reason:String) { // called by goroutine code that cannot continue, before it yields
+	grWaiting[gr]=reason;
+}
+static function runOne(gr:Int){ // called from above to call individual goroutines, once claim() has marked them as running
+	var outerGR:Int=currentGR; // if this is a nested call, the goroutine that called Haxe
+	var held:Int=heldLocks();
+	var inGoCode:Bool=false; // exceptions thrown while running the code of the goroutine become Go panics
+	var escaped:Dynamic=null; // an unrecovered panic or fatal error, thrown on once the goroutine is no longer running
+	try {
+		if(!grPanics[gr].isEmpty()) 
+			unwind(gr);
+		if(!grStacks[gr].isEmpty()) {
+			inGoCode=true;
+			run1(gr);
+		}
+	} catch(p:PanicRecord) {
+		// the stack is unwound the next time the goroutine is run
+		releaseLocks(held); // the panic may have been part way through a channel operation
+	} catch(e:Dynamic) {
+		releaseLocks(held);
+		if(inGoCode && e!=fatalError)
+			haxeException(gr,e);
+		else
+			escaped=e;
+	}
+	lock();
+	grInRun[gr]=false;
+	wakeWorkers(); // the goroutine may be claimed again
+	unlock();
+	currentGR=outerGR;
+	if(escaped!=null)
+		throw escaped;
+}
+// haxeException starts a Go panic for an exception thrown by Haxe code that Go called, the panic value is a *haxegoruntime.HaxeException,
+// unless the exception is a GoPanic from the goroutine of a nested call of Go code (see runFromHaxe), which continues with its original value
+static function haxeException(gr:Int,e:Dynamic) {
+	if(Std.is(e,GoPanic))
+		startPanic(gr,cast(e,GoPanic).value);
+	else
+		startPanic(gr,Go_haxegoruntime_MakeHaxeException.callFromRT(gr,e));
+}
+static var fatalError(get,set):Dynamic; // the error thrown by fatal() in this thread, which must not be turned into a Go panic
+public static function fatal(err:String) { // stop the program with a fatal error, which Go code cannot recover from
+	fatalError=err;
+	throw err;
+}
+// unwind takes the next step in unwinding the stack of a panicking goroutine, one deferred function at a time, 
+// each deferred function runs as normal goroutine code, so may itself block, panic or recover
+static function unwind(gr:Int){
+	var p:PanicRecord=grPanics[gr].first();
+	if(p.def!=null) {
+		if(p.def._incomplete) 
+			return; // the deferred function is still running
+		p.def=null; // the deferred function has returned
+	}
+	while(true){
+		var sf:StackFrame=grStacks[gr].first();
+		if(sf==null) {
+			if(p.goexit) { // the goroutine has ended
+				lock();
+				grPanics[gr]=new List<PanicRecord>();
+				if(gr==mainGR)
+					mainGoexit=true;
+				unlock();
+				return;
+			}
+			lock();
+			var dump:String=grPanicDump[gr];
+			unlock();
+			var gp:GoPanic=new GoPanic(p.val,panicChain(gr),"goroutine "+gr+" [running]:\n"+dump,gr); // use stored stack dump
+			if(watchedPanic(gr,gp)) { // the goroutine of a call from callAsync() has ended
+				lock();
+				grPanics[gr]=new List<PanicRecord>();
+				unlock();
+				return;
 			}
+			throw gp;
 		}
-	} else {
-		run1(gr);
+		if(p.recovered && p.frame!=sf) 
+			fatal("Scheduler: recovered panic has lost its stack frame\n"+stackDump());
+		if(!sf._deferStack.isEmpty()) {
+			p.frame=sf;
+			p.def=sf._deferStack.pop();
+			Scheduler.push(gr,p.def);
+			return;
+		}
+		if(p.recovered) { // the function that deferred the recover() now returns normally
+			lock();
+			grPanics[gr].pop();
+			unlock();
+			sf.resumeRecover();
+			return;
+		}
+		pop(gr);
+		// earlier panics that were running the deferred functions of this frame, or were running this frame as a deferred function, are aborted
+		lock();
+		grPanics[gr]=grPanics[gr].filter(function(q) return q==p || (q.frame!=sf && q.def!=sf));
+		unlock();
 	}
 }
 public static inline function run1(gr:Int){ // used by callFromRT() for every go function
 		if(grStacks[gr].first()==null) { 
-			throw "Panic:"+grPanicMsg+"\nScheduler: null stack entry for goroutine "+gr+"\n"+stackDump();
+			fatal("Scheduler: null stack entry for goroutine "+gr+"\n"+stackDump());
 		} else {
 			currentGR=gr;
 			grStacks[gr].first().run(); // run() may call haxe which calls these routines recursively 
 		}	
 }
 public static function makeGoroutine():Int {
-	for (r in 0 ... grStacks.length)
-		if(grStacks[r].isEmpty())
-		{
-			grInPanic[r]=false;
-			grPanicMsg[r]=null;
-			return r;	// reuse a previous goroutine number if possible
-		}
-	var l:Int=grStacks.length;
-	grStacks[l]=new List<StackFrame>();
-	grInPanic[l]=false;
-	grPanicMsg[l]=null;
-	return l;
-}
+	lock();
+	var r:Int=0;
+	while(r<grStacks.length && !(grStacks[r].isEmpty() && !grInRun[r] && !grNew[r]))
+		r++; // reuse a previous goroutine number if possible
+	if(r==grStacks.length)
+		grStacks[r]=new List<StackFrame>();
+	grPanics[r]=new List<PanicRecord>();
+	grPanicDump[r]="";
+	grWaiting[r]=null;
+	grSending[r]=notSending;
+	grReceiving[r]=false;
+	grAsleep[r]=false;
+	grInRun[r]=false;
+	grCallingHaxe[r]=false;
+	grNew[r]=true; // until push() is called for the goroutine
+	grSeen[r]=0;
+	grPreempt[r]=preemptBudget;
+	unlock();
+	return r;
+}
+public static function makeMainGoroutine():Int { // the goroutine for the Go main function, see mainGoexit
+	mainGR=makeGoroutine();
+	return mainGR;
+}
+// pop() and push() change grStacks and grNew under the lock, as claim() reads them in other threads and makeGoroutine() may grow the arrays
 public static function pop(gr:Int):StackFrame {
-	if(gr>=grStacks.length||gr<0)
-		throw "Scheduler.pop() invalid goroutine";
-	return grStacks[gr].pop();
+	lock();
+	if(gr>=grStacks.length||gr<0) {
+		unlock();
+		fatal("Scheduler.pop() invalid goroutine");
+	}
+	var sf:StackFrame=grStacks[gr].pop();
+	unlock();
+	return sf;
 }
 public static function push(gr:Int,sf:StackFrame){
-	if(gr>=grStacks.length||gr<0)
-		throw "Scheduler.push() invalid goroutine";
+	lock();
+	if(gr>=grStacks.length||gr<0) {
+		unlock();
+		fatal("Scheduler.push() invalid goroutine");
+	}
+	if(grStacks[gr].length>=maxStackDepth) {
+		unlock();
+		stackOverflow(gr,sf);
+	}
 	grStacks[gr].push(sf);
+	if(grNew[gr]) { // a new goroutine, which a parked worker may run
+		grNew[gr]=false;
+		wakeWorkers();
+	}
+	unlock();
+}
+// the maximum number of frames on the stack of each goroutine, set by the tardisgo -stacklimit flag,
+// the default is small enough that nested calls do not overflow the host stack of the target first
+public static var maxStackDepth:Int=#if cpp 10000 #else 2000 #end ;
+static function stackOverflow(gr:Int,sf:StackFrame) { // runaway recursion is a fatal error, which cannot be recovered
+	fatal("runtime: goroutine stack exceeds limit\nfatal error: stack overflow\n\ngoroutine "+gr+" [running]:\n"+
+		sf._functionName+"()\n\t"+Go.CPos(sf._functionPH)+"\n"+traceback(gr));
 }
 public static inline function NumGoroutine():Int {
 	return grStacks.length;
 }
 
+// When compiled with -D gothreads for the cpp, java or cs targets, goroutines are run by a pool of threads up to the GOMAXPROCS limit, 
+// each claiming a goroutine before running it. All the channel and scheduler state is guarded by a single lock.
+public static var maxProcs(default,null):Int=0; // the number of threads that may run goroutines at once, 0 until first set
+public static function setMaxProcs(n:Int):Int { // implements runtime.GOMAXPROCS(), returning the previous setting
+	if(maxProcs==0) { // as with gc, the default comes from the environment
+		maxProcs=1;
+		#if sys
+			var e:String=Sys.getEnv("GOMAXPROCS");
+			if(e!=null) {
+				var v:Null<Int>=Std.parseInt(e);
+				if(v!=null && v>0)
+					maxProcs=v;
+			}
+		#end
+	}
+	var prev:Int=maxProcs;
+	if(n>0)
+		maxProcs=n;
+	return prev;
+}
+#if (gothreads && (cpp || java || cs))
+static var schedLock:GoMutex=new GoMutex(); // re-entrant on every target
+static var workers:Int=0; // the number of worker threads running
+static var stopping:Bool=false; // set when main has returned, so the worker threads end
+static var threadError:Dynamic=null; // the first error thrown in a worker thread, rethrown by runAll() 
+static var parked:Int=0; // the number of worker threads waiting in worker() for a goroutine to become runnable
+static var workReady:GoLock=new GoLock(); // released once by wakeWorkers() for each parked worker thread
+#if cs
+	@:meta(System.ThreadStatic) static var csThreadState:GoThreadState;
+#else
+	static var tls:GoTls<GoThreadState>=new GoTls<GoThreadState>();
+#end
+static function threadState():GoThreadState {
+	#if cs
+		if(csThreadState==null)
+			csThreadState=new GoThreadState();
+		return csThreadState;
+	#else
+		var ts:GoThreadState=tls.value;
+		if(ts==null) {
+			ts=new GoThreadState();
+			tls.value=ts;
+		}
+		return ts;
+	#end
+}
+static function get_currentGR():Int { return threadState().gr; }
+static function set_currentGR(gr:Int):Int { return threadState().gr=gr; }
+static function get_fatalError():Dynamic { return threadState().fatalError; }
+static function set_fatalError(e:Dynamic):Dynamic { return threadState().fatalError=e; }
+static function worker(id:Int) { // the main loop of each worker thread, which runs goroutines alongside the thread that calls runAll()
+	try {
+		while(!stopping && id<maxProcs) {
+			var ran:Bool=false;
+			lock(); // makeGoroutine() may be growing grStacks
+			var n:Int=grStacks.length;
+			unlock();
+			for(gr in 0...n)
+				if(claim(gr)) {
+					runOne(gr);
+					ran=true;
+				}
+			if(!ran) { // park until another thread changes something
+				lock();
+				var park:Bool=!stopping;
+				for(gr in 0...grStacks.length) // a goroutine may have become runnable since it was looked at above
+					if(runnable(gr))
+						park=false;
+				if(park)
+					parked++;
+				unlock();
+				if(park)
+					workReady.wait();
+			}
+		}
+	} catch(e:Dynamic) { // the program has failed
+		lock();
+		if(threadError==null)
+			threadError=e;
+		unlock();
+	}
+	lock();
+	workers--;
+	unlock();
+}
+static function wakeWorkers() { // called with the lock held, when a goroutine may have become runnable
+	while(parked>0) {
+		parked--;
+		workReady.release();
+	}
+}
+#else
+static var singleGR:Int=-1;
+static inline function get_currentGR():Int { return singleGR; }
+static inline function set_currentGR(gr:Int):Int { return singleGR=gr; }
+static var singleFatalError:Dynamic=null;
+static inline function get_fatalError():Dynamic { return singleFatalError; }
+static inline function set_fatalError(e:Dynamic):Dynamic { return singleFatalError=e; }
+static inline function wakeWorkers() {}
+#end
+public static inline function lock() { // called around every channel operation, so that its test and action are atomic
+	#if (gothreads && (cpp || java || cs))
+		schedLock.acquire();
+		threadState().locks++;
+	#end
+}
+public static inline function unlock() {
+	#if (gothreads && (cpp || java || cs))
+		threadState().locks--;
+		schedLock.release();
+	#end
+}
+static inline function heldLocks():Int {
+	#if (gothreads && (cpp || java || cs))
+		return threadState().locks;
+	#else
+		return 0;
+	#end
+}
+static function releaseLocks(held:Int) { // after a panic, release the locks taken since there were only the held number
+	#if (gothreads && (cpp || java || cs))
+		while(heldLocks()>held)
+			unlock();
+	#end
+}
+static function startThreads() { // start worker threads up to the GOMAXPROCS limit
+	#if (gothreads && (cpp || java || cs))
+		if(maxProcs==0)
+			setMaxProcs(0);
+		lock();
+		while(workers<maxProcs-1) {
+			var id:Int=++workers;
+			GoThread.create(function() worker(id));
+		}
+		unlock();
+	#end
+}
+static function threadFailed() { // rethrow any error that ended a worker thread, so that the program fails as it would have on a single thread
+	#if (gothreads && (cpp || java || cs))
+		if(threadError!=null) {
+			fatalError=threadError;
+			throw threadError;
+		}
+	#end
+}
+public static function stopThreads() { // called when main returns, so that the worker threads do not keep the program running
+	#if (gothreads && (cpp || java || cs))
+		lock();
+		stopping=true;
+		wakeWorkers();
+		unlock();
+	#end
+}
+
 public static function stackDump():String {
 	var ret:String = "";
 	var gr:Int;
 	ret += "runAll() entryCount="+entryCount+"\n";
 	for(gr in 0...grStacks.length) {
-		ret += "Goroutine " + gr + " "+grPanicMsg[gr]+"\n"; //may need to unpack the interface
+		ret += "Goroutine " + gr + "\n";
 		if(grStacks[gr].isEmpty()) {
 			ret += "Stack is empty\n";
 		} else {
@@ -1668,40 +2725,127 @@ public static function stackDump():String {
 
 public static function traceStackDump() {trace(stackDump());}
 
+// runtime.Caller() and runtime.Callers() describe the calls on a goroutine's stack with program counter values, which are the PosHash 
+// of the latest position in each stack frame (only recorded when compiled with -debug, otherwise it is the start of the function)
+static var pcFuncs=new haxe.ds.IntMap<{name:String,entry:Int}>(); // the function of each pc given to Go, for runtime.FuncForPC()
+public static function callerPC(gr:Int,sf:StackFrame,skip:Int):Int { // the pc skip calls above frame sf (or of sf itself when skip is -1), 0 if none
+	var n:Int=-2;
+	for(f in grStacks[gr]) {
+		if(f==sf) 
+			n=-1;
+		if(n>=-1) {
+			if(n==skip) 
+				return framePC(f);
+			n++;
+		}
+	}
+	return 0;
+}
+static function framePC(f:StackFrame):Int {
+	var entry:Int=f._functionPH<0 ? -f._functionPH : f._functionPH;
+	var pc:Int=f._latestPH<0 ? -f._latestPH : f._latestPH;
+	if(pc==0) 
+		pc=entry;
+	if(pc==0) 
+		return 0;
+	var fn={name:goFuncName(f._functionName),entry:entry};
+	lock();
+	pcFuncs.set(pc,fn);
+	pcFuncs.set(entry,fn);
+	unlock();
+	return pc;
+}
+public static function pcFunc(pc:Int):{name:String,entry:Int} { // null if the pc was not given by callerPC()
+	lock();
+	var fn=pcFuncs.get(pc);
+	unlock();
+	return fn;
+}
+public static function pcFile(pc:Int):String {
+	var pos:String=Go.CPos(pc); // in the form "file:line"
+	var i:Int=pos.lastIndexOf(":");
+	return i<0 ? "" : pos.substr(0,i);
+}
+public static function pcLine(pc:Int):Int {
+	var pos:String=Go.CPos(pc);
+	var i:Int=pos.lastIndexOf(":");
+	var line:Null<Int>=i<0 ? null : Std.parseInt(pos.substr(i+1));
+	return line==null ? 0 : line;
+}
+static function goFuncName(haxeName:String):String { // the Go name of a function from the name of its Haxe class, for example "main.(*T).M"
+	var n:String=haxeName.substr(3); // remove "Go_"
+	var i:Int=n.indexOf("_"); // between the package and object names
+	if(i>=0) 
+		n=n.substr(0,i)+"."+n.substr(i+1);
+	n=StringTools.replace(StringTools.replace(n,"_dot_","."),"_star_","*");
+	return ~/_([0-9]+)_/g.map(n,function(r) return String.fromCharCode(Std.parseInt(r.matched(1))));
+}
+
 public static function panic(gr:Int,err:Interface){
 	if(gr>=grStacks.length||gr<0)
-		throw "Scheduler.panic() invalid goroutine";
-	if(!grInPanic[gr]) { // if we are already in a panic, keep the first message and stack-dump
-		grInPanic[gr]=true;
-		grPanicMsg[gr]=err;
-		panicStackDump=stackDump();
-	}
+		fatal("Scheduler.panic() invalid goroutine");
+	throw startPanic(gr,err); // caught by the scheduler, which then unwinds the stack
+}
+static function startPanic(gr:Int,err:Interface):PanicRecord {
+	var p:PanicRecord=new PanicRecord(err);
+	lock();
+	if(grPanics[gr].isEmpty()) // keep the stack-dump of the first panic
+		grPanicDump[gr]=stackDump();
+	grPanics[gr].push(p);
+	unlock();
+	return p;
 }
-public static function recover(gr:Int):Interface{
+public static function recover(gr:Int,sf:StackFrame):Interface{
 	if(gr>=grStacks.length||gr<0)
-		throw "Scheduler.recover() invalid goroutine";
-	grInPanic[gr]=false;
-	return grPanicMsg[gr];
-}
-public static function panicFromHaxe(err:String) { 
-	if(currentGR>=grStacks.length||currentGR<0) 
-		// if currnent goroutine is -ve, or out of range, always panics in goroutine 0
-		panic(0,new Interface(TypeInfo.getId("string"),"Runtime panic, unknown goroutine, "+err+" "));
-	else
-		panic(currentGR,new Interface(TypeInfo.getId("string"),"Runtime panic, "+err+" "));
-	throw panicStackDump;
+		fatal("Scheduler.recover() invalid goroutine");
+	var p:PanicRecord=grPanics[gr].first();
+	if(p==null || p.recovered || p.goexit || p.def!=sf) // only a function called directly by the deferral may recover the panic
+		return null;
+	p.recovered=true;
+	return p.val;
+}
+public static function goexit(gr:Int){ // runtime.Goexit()
+	if(gr>=grStacks.length||gr<0)
+		fatal("Scheduler.goexit() invalid goroutine");
+	var p:PanicRecord=new PanicRecord(null);
+	p.goexit=true;
+	lock();
+	grPanics[gr].push(p);
+	unlock();
+	throw p; // caught by the scheduler, which then runs the deferred functions of the goroutine
+}
+static function panicChain(gr:Int):String { // the message for an unrecovered panic, in the form gc uses
+	var ret:String="";
+	for(p in grPanics[gr]) // most recent first, but printed last
+		if(!p.goexit)
+			ret = "panic: " + panicString(gr,p.val) + (p.recovered ? " [recovered]" : "") + (ret=="" ? "" : "\n\t"+ret);
+	return ret;
+}
+static function panicString(gr:Int,v:Interface):String {
+	if(v==null) 
+		return "nil";
+	var r=Go_haxegoruntime_PanicString.callFromRT(gr,v);
+	if(r.r1) 
+		return r.r0;
+	return "("+TypeInfo.getName(v.typ)+") "+Std.string(v.val);
+}
+public static function panicFromHaxe(err:String) { // err is the message given by gc, the panic value implements runtime.Error
+	var gr:Int=currentGR;
+	if(gr>=grStacks.length||gr<0) 
+		gr=0; // if currnent goroutine is -ve, or out of range, always panics in goroutine 0
+	panic(gr,Go_haxegoruntime_MakeRuntimeError.callFromRT(gr,err));
 }
 public static function bbi() {
 	panicFromHaxe("bad block ID (internal phi error)");
 }
 public static function ioor() {
-	panicFromHaxe("index out of range");
+	panicFromHaxe("runtime error: index out of range");
 }
 public static inline function wraprangechk(val:Int,sz:Int) {
 	if((val<0)||(val>=sz)) ioor();
 }
 static function unp() {
-		panicFromHaxe("unexpected nil pointer (ssa:wrapnilchk)");	
+		panicFromHaxe("runtime error: invalid memory address or nil pointer dereference");	
 }
 public static inline function wrapnilchk(p:Pointer):Pointer {
 	if(p==null) unp();
@@ -1727,7 +2871,7 @@ class Pointer { // slow! heavy use of Dynamic typing and reflection
 		var ret:Dynamic = heapObj;
 		for(i in 0...offs.length) {
 			try	ret = ret[offs[i]]
-			catch (ret:Dynamic)	Scheduler.panicFromHaxe("failed attempt to dereference pointer reading from index:"+offs.toString());
+			catch (ret:Dynamic)	Scheduler.panicFromHaxe("runtime error: invalid memory address or nil pointer dereference");
 		}
 		return ret;
 	}
@@ -1738,10 +2882,10 @@ class Pointer { // slow! heavy use of Dynamic typing and reflection
 			var a:Dynamic = heapObj;
 			for(i in 0...offs.length-1) {
 				try a = a[offs[i]]
-				catch (a:Dynamic) Scheduler.panicFromHaxe("failed attempt to dereference pointer writing to index:"+offs.toString());
+				catch (a:Dynamic) Scheduler.panicFromHaxe("runtime error: invalid memory address or nil pointer dereference");
 			}
 			try a[offs[offs.length-1]] = v
-			catch (a:Dynamic) Scheduler.panicFromHaxe("failed attempt to dereference pointer writing to index:"+offs.toString());
+			catch (a:Dynamic) Scheduler.panicFromHaxe("runtime error: invalid memory address or nil pointer dereference");
 		}
 	}
 	public function addr(off:Int):Pointer {
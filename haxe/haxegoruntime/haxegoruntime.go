@@ -152,3 +152,94 @@ func StringCompare(a, b string) int {
 	}
 	return +1
 }
+
+// RuntimeError is the type of the value passed to panic() for the errors detected by the Haxe runtime,
+// it implements runtime.Error, so that recover() callers can use it in the same way as with gc.
+type RuntimeError string
+
+// RuntimeError marks the type as implementing runtime.Error.
+func (e RuntimeError) RuntimeError() {}
+
+// Error gives the message, in the form used by gc, such as "runtime error: index out of range".
+func (e RuntimeError) Error() string { return string(e) }
+
+// MakeRuntimeError is called by the Haxe runtime to create the value passed to panic() when it detects an error.
+func MakeRuntimeError(msg string) error { return RuntimeError(msg) }
+
+// HaxeException is the value passed to panic() when Haxe code called from Go throws an exception,
+// Value holds the Haxe value thrown.
+type HaxeException struct {
+	Value uintptr
+}
+
+// Error gives the Haxe value thrown, as a string.
+func (e *HaxeException) Error() string {
+	return "Haxe exception: " + hx.CodeString("Std.string(_a.itemAddr(0).load().val);", e.Value)
+}
+
+// MakeHaxeException is called by the Haxe runtime to create the value passed to panic() when Haxe code throws an exception.
+func MakeHaxeException(v uintptr) error { return &HaxeException{v} }
+
+type stringer interface {
+	String() string
+}
+
+// PanicString is called by the Haxe runtime to give the text that gc would print for an unrecovered panic with the value v,
+// ok is false if the value is not of a type that this function describes.
+func PanicString(v interface{}) (s string, ok bool) {
+	switch x := v.(type) {
+	case error:
+		return x.Error(), true
+	case stringer:
+		return x.String(), true
+	case string:
+		return x, true
+	case bool:
+		if x {
+			return "true", true
+		}
+		return "false", true
+	case int:
+		return itoa(int64(x)), true
+	case int8:
+		return itoa(int64(x)), true
+	case int16:
+		return itoa(int64(x)), true
+	case int32:
+		return itoa(int64(x)), true
+	case int64:
+		return itoa(x), true
+	case uint:
+		return itoa(int64(x)), true
+	case uint8:
+		return itoa(int64(x)), true
+	case uint16:
+		return itoa(int64(x)), true
+	case uint32:
+		return itoa(int64(x)), true
+	}
+	return "", false
+}
+
+func itoa(i int64) string {
+	if i == 0 {
+		return "0"
+	}
+	neg := i < 0
+	var buf [20]byte
+	p := len(buf)
+	for i != 0 {
+		d := i % 10
+		if d < 0 {
+			d = -d
+		}
+		p--
+		buf[p] = byte('0' + d)
+		i /= 10
+	}
+	if neg {
+		p--
+		buf[p] = '-'
+	}
+	return string(buf[p:])
+}
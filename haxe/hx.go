@@ -19,6 +19,7 @@ func (l langType) hxPseudoFuncs(fnToCall string, args []ssa.Value, errorInfo str
 	usesArgs := true
 	if strings.HasSuffix(fnToCall, "Iface") {
 		argOff = 1
+		ifaceTypeNames[strings.Trim(l.IndirectValue(args[0], errorInfo), `"`)] = true // so that it can be compared, see logIfaceType()
 		wrapStart = "new Interface(TypeInfo.getId(" + l.IndirectValue(args[0], errorInfo) + "),{"
 		wrapEnd = "});"
 	}
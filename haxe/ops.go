@@ -28,6 +28,9 @@ func (l langType) codeUnOp(op string, v interface{}, CommaOK bool, errorInfo str
 		return ""
 	case "*":
 		goTyp := v.(ssa.Value).Type().Underlying().(*types.Pointer).Elem().Underlying()
+		if pogo.IsScalarAlloc(v) {
+			return l.IndirectValue(v, errorInfo) + "/* scalar */"
+		}
 
 		//lt = l.LangType(goTyp, false, errorInfo)
 		iVal := "" + l.IndirectValue(v, errorInfo) + "" // need to cast it to pointer, when using -dce full and closures
@@ -142,6 +145,15 @@ func (l langType) codeBinOp(op string, v1, v2 interface{}, errorInfo string) str
 			return "(" + v1string + op + v2string + ")"
 		}
 
+	} else if isAggregateOrPointer(v1.(ssa.Value).Type()) && (op == "==" || op == "!=") {
+		// evaluate each operand once, then compare using Go equality
+		ret = "({var _a:" + v1LangType + "=" + v1string + ";var _b:" + v2LangType + "=" + v2string + ";" +
+			l.eqCode(v1.(ssa.Value).Type(), "_a", "_b") + ";})"
+		if op == "!=" {
+			ret = "!" + ret
+		}
+		return ret
+
 	} else if v1LangType == "Interface" {
 		switch op {
 		case "==":
@@ -83,6 +83,12 @@ func (l langType) LangType(t types.Type, retInitVal bool, errorInfo string) stri
 			}
 			return "Channel<" + l.LangType(t.(*types.Chan).Elem(), false, errorInfo) + ">"
 		case *types.Map:
+			if l.usesGoMap(t.(*types.Map), errorInfo) { // keys that need Go equality
+				if retInitVal {
+					return "new GoMap(" + pogo.LogTypeUse(t.(*types.Map).Key()) + ")"
+				}
+				return "GoMap"
+			}
 			if retInitVal {
 				return "new Map<" + l.LangType(t.(*types.Map).Key(), false, errorInfo) + "," +
 					l.LangType(t.(*types.Map).Elem(), false, errorInfo) + ">()"
@@ -281,6 +287,7 @@ func (l langType) Convert(register, langType string, destType types.Type, v inte
 }
 
 func (l langType) MakeInterface(register string, regTyp types.Type, v interface{}, errorInfo string) string {
+	logIfaceType(v.(ssa.Value).Type())
 	return register + `=new Interface(` + pogo.LogTypeUse(v.(ssa.Value).Type() /*NOT underlying()*/) + `,` +
 		l.IndirectValue(v, errorInfo) + ");"
 }
@@ -340,12 +347,18 @@ func (l langType) TypeAssert(register string, v ssa.Value, AssertedType types.Ty
 	if CommaOk {
 		return register + `=Interface.assertOk(` + pogo.LogTypeUse(AssertedType) + `,` + l.IndirectValue(v, errorInfo) + ");"
 	}
-	return register + `=Interface.assert(` + pogo.LogTypeUse(AssertedType) + `,` + l.IndirectValue(v, errorInfo) + ");"
+	return register + `=Interface.assert(` + pogo.LogTypeUse(AssertedType) + `,` + l.IndirectValue(v, errorInfo) +
+		`,` + pogo.LogTypeUse(v.Type()) + ");"
 }
 
 func (l langType) EmitTypeInfo() string {
 	ret := "class TypeInfo{\n"
 	pte := pogo.TypesEncountered
+	for _, T := range pte.Keys() { // map key types are needed for hashing, register them before the list is taken
+		if m, ok := T.Underlying().(*types.Map); ok {
+			pogo.LogTypeUse(m.Key())
+		}
+	}
 	pteKeys := pogo.TypesEncountered.Keys()
 
 	ret += "public static function getName(id:Int):String {\nswitch(id){" + "\n"
@@ -416,6 +429,8 @@ func (l langType) EmitTypeInfo() string {
 	}
 	ret += "default: return null;}}\n"
 
+	ret += l.emitEqualityAndHash(pteKeys, pte.At)
+
 	ret += "public static function method(t:Int,m:String):Dynamic {\nswitch(t){" + "\n"
 
 	tta := pogo.TypesWithMethodSets() //[]types.Type
@@ -28,6 +28,12 @@ var DebugFlag bool
 // TraceFlag is used to signal if we are emitting trace information (big)
 var TraceFlag bool
 
+// PreemptFlag is used to signal that loops in goroutine-using functions should periodically give up control
+var PreemptFlag bool
+
+// StackLimit is the maximum number of frames on a goroutine's stack, 0 leaves the default for the target
+var StackLimit int
+
 // EntryPoint provides the entry point for the pogo package, called from ssadump_copy.
 func EntryPoint(mainPkg *ssa.Package) error {
 	mainPackage = mainPkg
@@ -137,6 +143,7 @@ func emitFileEnd() {
 	for w := range warnings {
 		emitComment(warnings[w])
 	}
+	emitStats()
 	emitComment("Package List:")
 	allPack := rootProgram.AllPackages()
 	for pkgIdx := range allPack {
@@ -0,0 +1,40 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pogo
+
+import (
+	"code.google.com/p/go.tools/go/ssa"
+
+	"github.com/tardisgo/tardisgo/tgossa"
+)
+
+// NoBoundsCheck is used to signal that no index range checks should be emitted, for trusted release builds only.
+// The bounds of slice expressions are still checked, by the runtime.
+var NoBoundsCheck bool
+
+var inRangeIndexes map[ssa.Instruction]bool // the Index and IndexAddr instructions in the current function that need no range check
+
+// Find the indexes in a function, and in the functions inlined into it, that are provably in range.
+func setupInRangeIndexes(fn *ssa.Function) {
+	inRangeIndexes = tgossa.InRangeIndexes(fn)
+	for _, call := range inlineOrder {
+		for in := range tgossa.InRangeIndexes(inlineSites[call].callee) {
+			inRangeIndexes[in] = true
+		}
+	}
+}
+
+// needsRangeCheck returns false if the Index or IndexAddr instruction is known to be in range, or checks are turned off.
+// It is only asked about indexes that would otherwise be checked, so constant indexes into arrays are not counted as removed.
+func needsRangeCheck(in ssa.Instruction) bool {
+	if NoBoundsCheck {
+		return false
+	}
+	if inRangeIndexes[in] {
+		optStats.rangeChecksRemoved++
+		return false
+	}
+	return true
+}
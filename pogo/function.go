@@ -29,7 +29,8 @@ func emitFunctions() {
 			dceList = append(dceList, exip)
 		}
 	}
-	fnMap, grMap = tgossa.VisitedFunctions(rootProgram, dceList)
+	fnMap, grMap = tgossa.VisitedFunctions(rootProgram, dceList, prepareFunction)
+	setupInlineMap()
 	/*
 		fmt.Println("DEBUG funcs not requiring goroutines:")
 		for df, db := range grMap {
@@ -239,6 +240,9 @@ func emitFunc(fn *ssa.Function) {
 			}
 		}
 
+		setupInlineSites(fn)
+		setupScalarAllocs(fn)
+		setupInRangeIndexes(fn)
 		emitFuncStart(fn, trackPhi, canOptMap, mustSplitCode)
 		thisSubFn := 0
 		for b := range fn.Blocks {
@@ -0,0 +1,173 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pogo
+
+import (
+	"fmt"
+	"strings"
+
+	"code.google.com/p/go.tools/go/ssa"
+	"code.google.com/p/go.tools/go/types"
+
+	"github.com/tardisgo/tardisgo/tgossa"
+)
+
+// InlineThreshold is the maximum number of SSA instructions in a function that will be inlined, 0 turns inlining off.
+var InlineThreshold int
+
+var inlineMap map[*ssa.Function]bool // which functions can be inlined
+
+// An inlineSite is a call whose callee's body is emitted in place of the call.
+type inlineSite struct {
+	callee *ssa.Function
+	args   map[*ssa.Parameter]ssa.Value // the caller values that replace the callee parameters
+	prefix string                       // added to the callee register names, to make them unique in the caller
+}
+
+var inlineSites map[*ssa.Call]*inlineSite // the inlined calls in the function currently being emitted
+var inlineOrder []*ssa.Call               // the same calls, in the order that they appear in the function
+var inlining *inlineSite                  // the site currently being emitted, or nil
+
+// Build the inlineMap, excluding those functions that the target language treats specially.
+func setupInlineMap() {
+	inlineMap = tgossa.InlineCandidates(fnMap, grMap, InlineThreshold, func(fn *ssa.Function) bool {
+		if isRewritten(fn) {
+			return true
+		}
+		if fn.Signature.Results().Len() == 1 {
+			if b, ok := fn.Signature.Results().At(0).Type().Underlying().(*types.Basic); ok && b.Kind() == types.UnsafePointer {
+				return true // result copy would be treated as a conversion
+			}
+		}
+		return false
+	})
+}
+
+// isRewritten returns true if the target language may treat the function specially, rather than simply calling it,
+// or if its package is not known.
+func isRewritten(fn *ssa.Function) bool {
+	l := TargetLang
+	if fn.Pkg == nil || fn.Pkg.Object == nil {
+		return true // synthetic functions may not have a package
+	}
+	if strings.HasPrefix(fn.Pkg.Object.Path(), "github.com/tardisgo/tardisgo/tardisgolib") {
+		return true // the pseudo-functions in these packages are re-written by the target language
+	}
+	pn := fn.Pkg.Object.Name()
+	_, _, pov := LanguageList[l].PackageOverloaded(pn)
+	return pov || LanguageList[l].FunctionOverloaded(pn, fn.Name()) || strings.HasPrefix(pn, "_")
+}
+
+// Find the calls in a function that will be inlined, giving each a unique register prefix.
+func setupInlineSites(fn *ssa.Function) {
+	inlineSites = make(map[*ssa.Call]*inlineSite)
+	inlineOrder = nil
+	inlining = nil
+	for _, b := range fn.Blocks {
+		for _, in := range b.Instrs {
+			call, isCall := in.(*ssa.Call)
+			if !isCall || call.Call.IsInvoke() {
+				continue
+			}
+			callee := call.Call.StaticCallee()
+			if callee == nil || callee == fn || !(inlineMap[callee] || canInlineNilCheckWrapper(call, callee)) {
+				continue
+			}
+			site := &inlineSite{
+				callee: callee,
+				args:   make(map[*ssa.Parameter]ssa.Value),
+				prefix: fmt.Sprintf("inl%d_", len(inlineOrder)),
+			}
+			for p := range callee.Params {
+				site.args[callee.Params[p]] = call.Call.Args[p]
+			}
+			inlineSites[call] = site
+			inlineOrder = append(inlineOrder, call)
+		}
+	}
+}
+
+// canInlineNilCheckWrapper returns true if the callee is a wrapper that nil checks its pointer, and the caller's pointer
+// cannot be nil, so that the wrapper can be inlined without its check, see tgossa.IsNilCheckWrapper().
+// Such wrappers are not leaf functions, but the one call they make is emitted as a normal call from the caller.
+func canInlineNilCheckWrapper(call *ssa.Call, callee *ssa.Function) bool {
+	return InlineThreshold > 0 && !grMap[callee] && tgossa.IsNilCheckWrapper(callee) &&
+		tgossa.NonNil(call.Call.Args[0], call)
+}
+
+// IsInlined returns true if the instruction is a call that is emitted as an in-line copy of its callee.
+func IsInlined(in ssa.Instruction) bool {
+	call, isCall := in.(*ssa.Call)
+	if !isCall {
+		return false
+	}
+	_, ok := inlineSites[call]
+	return ok
+}
+
+// InlinedCalls returns the number of calls, other than to builtins, made by the inlined copy of the callee of the call instruction,
+// which is only ever more than zero for a nil check wrapper, see canInlineNilCheckWrapper().
+func InlinedCalls(in ssa.Instruction) int {
+	count := 0
+	for _, cin := range inlineSites[in.(*ssa.Call)].callee.Blocks[0].Instrs {
+		if call, isCall := cin.(*ssa.Call); isCall {
+			if _, isBuiltin := call.Call.Value.(*ssa.Builtin); !isBuiltin {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// InlinedInstrs calls f for each instruction copied into the current function by inlining,
+// with the register names set-up as they will be when the instructions are emitted.
+func InlinedInstrs(f func(in ssa.Instruction)) {
+	for _, call := range inlineOrder {
+		inlining = inlineSites[call]
+		for _, in := range inlining.callee.Blocks[0].Instrs {
+			f(in)
+		}
+	}
+	inlining = nil
+}
+
+// InlinedArg returns the caller's value that replaces the given parameter in an inlined function body, or nil.
+func InlinedArg(p *ssa.Parameter) ssa.Value {
+	if inlining == nil {
+		return nil
+	}
+	return inlining.args[p]
+}
+
+// Emit the body of the callee in place of the call instruction.
+func emitInlinedCall(call *ssa.Call, register, errorInfo, comment string) {
+	l := TargetLang
+	emitComment(comment + " [INLINED]")
+	optStats.inlinedCalls++
+	inlining = inlineSites[call]
+	for _, in := range inlining.callee.Blocks[0].Instrs {
+		switch in.(type) {
+		case *ssa.DebugRef:
+			// NoOp
+		case *ssa.Return:
+			if register != "" && len(in.(*ssa.Return).Results) == 1 {
+				fmt.Fprintln(&LanguageList[l].buffer,
+					LanguageList[l].ChangeType(register, call.Type(), in.(*ssa.Return).Results[0], errorInfo))
+			}
+		case *ssa.Call:
+			if in == ssa.Instruction(tgossa.NilCheckIn(inlining.callee)) { // the caller's pointer is known to be non-nil
+				optStats.nilChecksRemoved++
+				fmt.Fprintln(&LanguageList[l].buffer,
+					LanguageList[l].ChangeType(RegisterName(in.(*ssa.Call)), in.(*ssa.Call).Type(), in.(*ssa.Call).Call.Args[0], errorInfo)+
+						LanguageList[l].Comment("nil check removed"))
+				break
+			}
+			emitInstruction(in, in.Operands(make([]*ssa.Value, 0)))
+		default:
+			emitInstruction(in, in.Operands(make([]*ssa.Value, 0)))
+		}
+	}
+	inlining = nil
+}
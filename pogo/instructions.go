@@ -15,6 +15,11 @@ import (
 // RegisterName returns the name of an ssa.Value, a utility function in case it needs to be altered.
 func RegisterName(val ssa.Value) string {
 	//NOTE the SSA code says that name() should not be relied on, so this code may need to alter
+	if inlining != nil { // registers copied from an inlined function need unique names in the caller
+		if in, isInstr := val.(ssa.Instruction); isInstr && in.Parent() == inlining.callee {
+			return "_" + inlining.prefix + val.Name()
+		}
+	}
 	return "_" + val.Name()
 }
 
@@ -74,6 +79,11 @@ func emitInstruction(instruction interface{}, operands []*ssa.Value) (emitPhiFla
 			LanguageList[l].Jump(instruction.(*ssa.Jump).Block().Succs[0].Index)+LanguageList[l].Comment(comment))
 
 	case *ssa.If:
+		if len(instruction.(*ssa.If).Block().Succs) == 1 { // the condition was constant, see tgossa.ConstProp()
+			fmt.Fprintln(&LanguageList[l].buffer,
+				LanguageList[l].Jump(instruction.(*ssa.If).Block().Succs[0].Index)+LanguageList[l].Comment(comment))
+			break
+		}
 		fmt.Fprintln(&LanguageList[l].buffer,
 			LanguageList[l].If(*operands[0],
 				instruction.(*ssa.If).Block().Succs[0].Index,
@@ -95,7 +105,9 @@ func emitInstruction(instruction interface{}, operands []*ssa.Value) (emitPhiFla
 		fmt.Fprintln(&LanguageList[l].buffer, text+LanguageList[l].Comment(comment))
 
 	case *ssa.Call:
-		if instruction.(*ssa.Call).Call.IsInvoke() {
+		if IsInlined(instruction.(*ssa.Call)) {
+			emitInlinedCall(instruction.(*ssa.Call), register, errorInfo, comment)
+		} else if instruction.(*ssa.Call).Call.IsInvoke() {
 			fmt.Fprintln(&LanguageList[l].buffer,
 				LanguageList[l].EmitInvoke(register, false, false, grMap[instruction.(*ssa.Call).Parent()], instruction.(*ssa.Call).Call, errorInfo)+
 					LanguageList[l].Comment(comment))
@@ -221,9 +233,11 @@ func emitInstruction(instruction interface{}, operands []*ssa.Value) (emitPhiFla
 				LanguageList[l].Comment(comment))
 
 	case *ssa.Alloc:
+		if IsScalarAlloc(instruction) {
+			optStats.scalarAllocs++
+		}
 		fmt.Fprintln(&LanguageList[l].buffer,
-			LanguageList[l].Alloc(register,
-				instruction.(*ssa.Alloc).Type() /*was+: .(*types.Pointer).Elem() */, errorInfo)+
+			LanguageList[l].Alloc(register, instruction, errorInfo)+
 				LanguageList[l].Comment(comment))
 
 	case *ssa.MakeClosure:
@@ -282,14 +296,12 @@ func emitInstruction(instruction interface{}, operands []*ssa.Value) (emitPhiFla
 		}
 
 	case *ssa.Slice:
-		// TODO see http://tip.golang.org/doc/go1.2#three_index
-		// TODO add third parameter when SSA code provides it to enable slice instructions to specify a capacity
 		if register == "" {
 			emitComment(comment)
 		} else {
 			fmt.Fprintln(&LanguageList[l].buffer,
 				LanguageList[l].Slice(register, instruction.(*ssa.Slice).X,
-					instruction.(*ssa.Slice).Low, instruction.(*ssa.Slice).High, errorInfo)+
+					instruction.(*ssa.Slice).Low, instruction.(*ssa.Slice).High, instruction.(*ssa.Slice).Max, errorInfo)+
 					LanguageList[l].Comment(comment))
 
 		}
@@ -320,7 +332,7 @@ func emitInstruction(instruction interface{}, operands []*ssa.Value) (emitPhiFla
 					doRangeCheck = false
 				}
 			}
-			if doRangeCheck {
+			if doRangeCheck && needsRangeCheck(instruction.(ssa.Instruction)) {
 				fmt.Fprintln(&LanguageList[l].buffer,
 					LanguageList[l].RangeCheck(instruction.(*ssa.Index).X, instruction.(*ssa.Index).Index, aLen, errorInfo))
 			}
@@ -354,7 +366,7 @@ func emitInstruction(instruction interface{}, operands []*ssa.Value) (emitPhiFla
 					doRangeCheck = false
 				}
 			}
-			if doRangeCheck { // now inside Addr function to reduce emitted code size
+			if doRangeCheck && needsRangeCheck(instruction.(ssa.Instruction)) { // now inside Addr function to reduce emitted code size
 				fmt.Fprintln(&LanguageList[l].buffer,
 					LanguageList[l].RangeCheck(instruction.(*ssa.IndexAddr).X, instruction.(*ssa.IndexAddr).Index, aLen, errorInfo)+
 						LanguageList[l].Comment(comment+" [POINTER]"))
@@ -62,7 +62,7 @@ type Language interface {
 	MakeSlice(register string, v interface{}, errorInfo string) string
 	MakeChan(register string, v interface{}, errorInfo string) string
 	MakeMap(register string, v interface{}, errorInfo string) string
-	Slice(register string, x, low, high interface{}, errorInfo string) string
+	Slice(register string, x, low, high, max interface{}, errorInfo string) string
 	Index(register string, v1, v2 interface{}, errorInfo string) string
 	RangeCheck(x, i interface{}, length int, errorInfo string) string
 	Field(register string, v interface{}, fNum int, name, errorInfo string, isFunctionName bool) string
@@ -9,6 +9,8 @@ import (
 
 	"code.google.com/p/go.tools/go/ssa"
 	"code.google.com/p/go.tools/go/types"
+
+	"github.com/tardisgo/tardisgo/tgossa"
 )
 
 // This function in case special handing is required for pointers.
@@ -16,6 +18,29 @@ func scanForPointers() {
 	return //NoOp TODO consider removing function
 }
 
+var scalarAllocs map[*ssa.Alloc]bool // the allocations in the function currently being emitted that are held as scalars
+
+// Find the allocations in a function, and in the functions inlined into it, whose values can be held in local variables.
+func setupScalarAllocs(fn *ssa.Function) {
+	scalarAllocs = tgossa.ScalarAllocs(fn)
+	for _, call := range inlineOrder {
+		for a := range tgossa.ScalarAllocs(inlineSites[call].callee) {
+			scalarAllocs[a] = true
+		}
+	}
+}
+
+// IsScalarAlloc returns true if the value is an Alloc whose address never escapes the function being emitted,
+// and which only holds a single non-aggregate value, so the target language can hold that value in a local variable
+// rather than allocating an object and a pointer to it.
+func IsScalarAlloc(v interface{}) bool {
+	a, ok := v.(*ssa.Alloc)
+	if !ok {
+		return false
+	}
+	return scalarAllocs[a]
+}
+
 // is this value a pointer?
 func valIsPointer(v interface{}) bool {
 	switch v.(type) {
@@ -0,0 +1,35 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pogo
+
+import (
+	"code.google.com/p/go.tools/go/exact"
+	"code.google.com/p/go.tools/go/ssa"
+
+	"github.com/tardisgo/tardisgo/tgossa"
+)
+
+// prepareFunction is called for each function as it is first visited, before any code is emitted,
+// so that the branches and calls which the optimizations remove are not visited either.
+func prepareFunction(fn *ssa.Function) {
+	folded, pruned := tgossa.ConstProp(fn, constCall)
+	optStats.constsFolded += folded
+	optStats.blocksPruned += pruned
+}
+
+// constCall gives the constant result of calling fn, or nil if that is not known.
+// NOTE tardisgolib.Host() is the only pseudo-function with a constant result for a given target language,
+// the others, like Zilen() and Platform(), vary between the platforms of that language so must be evaluated at runtime.
+func constCall(fn *ssa.Function) *ssa.Const {
+	if fn.Pkg != nil && fn.Pkg.Object != nil &&
+		fn.Pkg.Object.Path() == "github.com/tardisgo/tardisgo/tardisgolib" && fn.Name() == "Host" {
+		return ssa.NewConst(exact.MakeString(LanguageList[TargetLang].LanguageName()),
+			fn.Signature.Results().At(0).Type())
+	}
+	if isRewritten(fn) {
+		return nil
+	}
+	return tgossa.ConstResult(fn)
+}
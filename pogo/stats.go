@@ -0,0 +1,38 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package pogo
+
+import (
+	"fmt"
+	"os"
+)
+
+// StatsFlag is used to signal if we are outputting statistics about the optimizations made
+var StatsFlag bool
+
+// counts of the optimizations made, output if StatsFlag is set
+var optStats struct {
+	inlinedCalls, scalarAllocs, constsFolded, blocksPruned, rangeChecksRemoved, nilChecksRemoved int
+}
+
+// emit the optimization statistics as comments at the end of the output file, and to stderr
+func emitStats() {
+	if !StatsFlag {
+		return
+	}
+	lines := []string{
+		"Optimization statistics:",
+		fmt.Sprintf(" calls inlined: %d", optStats.inlinedCalls),
+		fmt.Sprintf(" allocations held as scalars: %d", optStats.scalarAllocs),
+		fmt.Sprintf(" constants folded: %d", optStats.constsFolded),
+		fmt.Sprintf(" dead blocks pruned: %d", optStats.blocksPruned),
+		fmt.Sprintf(" index range checks removed: %d", optStats.rangeChecksRemoved),
+		fmt.Sprintf(" nil checks removed: %d", optStats.nilChecksRemoved),
+	}
+	for _, l := range lines {
+		emitComment(l)
+		fmt.Fprintln(os.Stderr, l)
+	}
+}
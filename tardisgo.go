@@ -60,6 +60,11 @@ T	[T]race execution of the program.  Best for single-threaded programs!
 var allFlag = flag.Bool("testall", false, "For all targets: invokes the Haxe compiler (output ignored) and then runs the compiled program on the command line (OSX only)")
 var debugFlag = flag.Bool("debug", false, "Instrument the code to give more meaningful information during a stack dump")
 var traceFlag = flag.Bool("trace", false, "Output trace information for every block visited (warning: huge output)")
+var statsFlag = flag.Bool("stats", false, "Output statistics about the optimizations made")
+var noBoundsFlag = flag.Bool("B", false, "Disable all index range checks, but not the bounds checks of slice expressions (only for trusted release builds)")
+var preemptFlag = flag.Bool("preempt", false, "Let other goroutines run from time to time at the end of each loop iteration, in functions that use goroutines")
+var stackLimitFlag = flag.Int("stacklimit", 0, "The maximum number of function calls on a goroutine's stack, before it fails with a stack overflow (0 = the default for the target)")
+var inlineFlag = flag.Int("inline", 0, "Inline small leaf functions of up to this many SSA instructions into their callers (0 = off)")
 
 // TARDIS Go modification TODO review words here
 const usage = `SSA builder and TARDIS Go transpiler (version 0.0.1-experimental).
@@ -308,6 +313,11 @@ func doTestable(args []string) error {
 		*/
 		pogo.DebugFlag = *debugFlag
 		pogo.TraceFlag = *traceFlag
+		pogo.PreemptFlag = *preemptFlag
+		pogo.StackLimit = *stackLimitFlag
+		pogo.InlineThreshold = *inlineFlag
+		pogo.NoBoundsCheck = *noBoundsFlag
+		pogo.StatsFlag = *statsFlag
 		err = pogo.EntryPoint(main) // TARDIS Go entry point, returns an error
 		if err != nil {
 			return err
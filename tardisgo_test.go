@@ -19,13 +19,16 @@ func TestCore(t *testing.T) {
 		t.Error(err)
 	}
 
-	out, err := exec.Command("haxe", "-main", "tardis.Go", "--no-inline", "--interp").CombinedOutput()
-	if err != nil {
-		t.Error(err)
-	}
+	// run the tests both to completion from main(), and in time slices using Go.pump() (-D goasync)
+	for _, defs := range [][]string{nil, {"-D", "goasync"}} {
+		out, err := exec.Command("haxe", append([]string{"-main", "tardis.Go", "--no-inline", "--interp"}, defs...)...).CombinedOutput()
+		if err != nil {
+			t.Error(defs, err)
+		}
 
-	// any Haxe output would signal an error
-	if len(out) > 0 {
-		t.Errorf(string(out))
+		// any Haxe output would signal an error
+		if len(out) > 0 {
+			t.Errorf("%v %s", defs, out)
+		}
 	}
 }
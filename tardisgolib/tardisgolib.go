@@ -5,7 +5,11 @@
 // Package tardisgolib provides utility library functions for Go code targeting TARDIS Go
 package tardisgolib
 
-import "github.com/tardisgo/tardisgo/tardisgolib/hx"
+import (
+	"runtime"
+
+	"github.com/tardisgo/tardisgo/tardisgolib/hx"
+)
 
 // Host returns the Host language (i.e. "go" or "haxe"), the return value is overridden to give correct host language name
 func Host() string { return "go" }
@@ -38,7 +42,9 @@ func Gosched() {
 	case <-gosched_chan: // should never happen
 		return
 	default:
-		return
+	}
+	if Host() == "go" { // the select above only gives up control when running in Haxe
+		runtime.Gosched()
 	}
 }
 
@@ -46,3 +52,10 @@ func Gosched() {
 func NumGoroutine() int {
 	return hx.CodeInt("Scheduler.NumGoroutine();")
 }
+
+// Goexit runs the deferred calls of the current goroutine, then ends it.
+// When called from the main goroutine, the program fails once all the other goroutines have ended.
+func Goexit() {
+	hx.Code("Scheduler.goexit(this._goroutine);") // does not return when running in Haxe
+	runtime.Goexit()
+}
@@ -0,0 +1,22 @@
+// This program must fail with the panic of a Go function called from Haxe using callAsync(),
+// because the call has no panic callback, see coretests.sh
+package main
+
+import (
+	"runtime"
+
+	"github.com/tardisgo/tardisgo/tardisgolib/hx"
+)
+
+// Fail is called from Haxe using callAsync()
+func Fail() {
+	panic("nothing handles this")
+}
+
+func main() {
+	hx.Code(`Go_main_Fail.callAsync();`)
+	for i := 0; i < 100; i++ {
+		runtime.Gosched() // the call completes while main yields, ending the program
+	}
+	println("the panic was not reported")
+}
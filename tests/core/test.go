@@ -15,11 +15,12 @@ import (
 
 	"github.com/tardisgo/tardisgo/tardisgolib"
 	"github.com/tardisgo/tardisgo/tardisgolib/hx"
-	//"runtime"
+	"runtime"
 	//"strconv"
 	//"strings"
 	//"sync" // keep these two for now...
-	//"sync/atomic"
+	"sync/atomic"
+	"time"
 	"unicode/utf8"
 
 	// final one at end to match the constant declaration
@@ -1344,6 +1345,399 @@ func testDefer() {
 	TEQ(tardisgolib.CPos(), tddCount, 6)
 }
 
+func recoverNamed() (r int, s string) {
+	defer func() {
+		if x := recover(); x != nil {
+			s = x.(string)
+		}
+	}()
+	r = 42
+	panic("named")
+}
+
+func recoverIndirect() interface{} {
+	return recover() // not called directly by a deferred function, so returns nil
+}
+
+func recoverNested() (order string) {
+	defer func() {
+		order += "(" + recover().(string) + ")" // the latest panic replaces the earlier one
+	}()
+	defer func() {
+		order += "b"
+		panic("second")
+	}()
+	defer func() {
+		order += "a"
+		TEQ(tardisgolib.CPos(), recoverIndirect(), nil)
+	}()
+	panic("first")
+}
+
+func recoverInner(trail *string) {
+	defer func() {
+		*trail += "inner"
+		recover()
+	}()
+	defer func() { *trail += "2" }()
+	defer func() { *trail += "1" }()
+	panic("inner")
+}
+
+func testPanicRecover() {
+	TEQ(tardisgolib.CPos(), recover(), nil) // not panicking
+	r, s := recoverNamed()
+	TEQ(tardisgolib.CPos(), r, 42)
+	TEQ(tardisgolib.CPos(), s, "named")
+	TEQ(tardisgolib.CPos(), recoverNested(), "ab(second)")
+	trail := ""
+	func() {
+		defer func() { trail += "outer" }()
+		recoverInner(&trail)
+		trail += "-"
+	}()
+	TEQ(tardisgolib.CPos(), trail, "12inner-outer") // recovering stops the unwind in recoverInner
+}
+
+func testGoexit() {
+	done := make(chan string)
+	go func() {
+		trail := ""
+		defer func() { done <- trail }()
+		func() {
+			defer func() {
+				trail += "inner"
+				if recover() != nil { // Goexit is not a panic
+					trail += "recovered"
+				}
+			}()
+			tardisgolib.Goexit()
+			trail += "not reached"
+		}()
+		trail += "not reached"
+	}()
+	TEQ(tardisgolib.CPos(), <-done, "inner")
+}
+
+func testHaxeException() {
+	if tardisgolib.Host() != "haxe" {
+		return
+	}
+	msg := ""
+	func() {
+		defer func() {
+			if e, ok := recover().(error); ok {
+				msg = e.Error()
+			}
+		}()
+		hx.Code(`throw "bang";`)
+		msg = "not reached"
+	}()
+	TEQ(tardisgolib.CPos(), msg, "Haxe exception: bang")
+}
+
+// HostCallPanic is called from Haxe by testHostCallPanic, while another goroutine panics and recovers
+func HostCallPanic(s string) {
+	done := make(chan bool)
+	go func() {
+		defer func() {
+			recover()
+			done <- true
+		}()
+		panic("other")
+	}()
+	<-done
+	panic(s)
+}
+
+// only the panic of the goroutine that Haxe called is passed back to the Go code that called Haxe
+func testHostCallPanic() {
+	var got interface{}
+	func() {
+		defer func() {
+			got = recover()
+		}()
+		if tardisgolib.Host() == "haxe" {
+			hx.Code(`Go_main_HostCallPanic.callFromHaxe("mine");`)
+		} else {
+			HostCallPanic("mine")
+		}
+	}()
+	TEQ(tardisgolib.CPos(), got, "mine")
+}
+
+var reentryReq, reentryRep = make(chan int), make(chan int)
+
+// reentryServer doubles the numbers it is sent, it is blocked waiting for a request whenever Haxe calls Go below
+func reentryServer() {
+	for n := range reentryReq {
+		reentryRep <- n * 2
+	}
+	close(reentryRep)
+}
+
+// HostCallReentry is called from Haxe while the goroutine of its caller waits for Haxe to return,
+// depth more calls of Go from Haxe are nested inside it, and a negative n panics in the innermost one,
+// where -2 throws a Haxe exception instead
+func HostCallReentry(n, depth int) int {
+	if depth > 0 {
+		return hostCallReentry(n, depth-1)
+	}
+	if n < 0 {
+		if n == -2 && tardisgolib.Host() == "haxe" {
+			hx.Code(`throw "reentry";`)
+		}
+		panic("reentry")
+	}
+	reentryReq <- n
+	return <-reentryRep
+}
+
+func hostCallReentry(n, depth int) int {
+	if tardisgolib.Host() == "haxe" {
+		return hx.CodeInt(`Go_main_HostCallReentry.callFromHaxe(_a.itemAddr(0).load().val,_a.itemAddr(1).load().val);`, n, depth)
+	}
+	return HostCallReentry(n, depth)
+}
+
+func testHostCallReentry() {
+	go reentryServer()
+	TEQ(tardisgolib.CPos(), hostCallReentry(1, 0), 2)
+	TEQ(tardisgolib.CPos(), hostCallReentry(2, 2), 4)
+	var got interface{}
+	func() {
+		defer func() {
+			got = recover()
+		}()
+		hostCallReentry(-1, 2)
+	}()
+	TEQ(tardisgolib.CPos(), got, "reentry")
+	if tardisgolib.Host() == "haxe" {
+		func() {
+			defer func() {
+				if e, ok := recover().(error); ok {
+					got = e.Error()
+				}
+			}()
+			hostCallReentry(-2, 1)
+		}()
+		TEQ(tardisgolib.CPos(), got, "Haxe exception: reentry")
+	}
+	TEQ(tardisgolib.CPos(), hostCallReentry(3, 1), 6) // the calls that panicked no longer count as calling Haxe
+	close(reentryReq)
+	_, ok := <-reentryRep
+	TEQ(tardisgolib.CPos(), ok, false)
+}
+
+var asyncIn = make(chan int)
+
+// AsyncDouble is called from Haxe using callAsync() by testGoFuture, it blocks until it is sent a number
+func AsyncDouble() int {
+	n := <-asyncIn
+	if n < 0 {
+		panic("negative")
+	}
+	return n * 2
+}
+
+// asyncDouble returns the GoFuture of a call of AsyncDouble, once it has completed,
+// with a panic callback, as a panic that nothing handles would end the program (see tests/asyncpanic)
+func asyncDouble(n int) uintptr {
+	fut := hx.CodeDynamic(`Go_main_AsyncDouble.callAsync().then(function(_){},function(_){});`)
+	for i := 0; i < 10; i++ {
+		tardisgolib.Gosched()
+	}
+	TEQ(tardisgolib.CPos(), hx.CodeBool(`_a.itemAddr(0).load().val.done;`, fut), false) // blocked in the new goroutine
+	asyncIn <- n
+	for !hx.CodeBool(`_a.itemAddr(0).load().val.done;`, fut) {
+		tardisgolib.Gosched()
+	}
+	return fut
+}
+
+func testGoFuture() {
+	if tardisgolib.Host() != "haxe" {
+		return
+	}
+	fut := asyncDouble(21)
+	TEQ(tardisgolib.CPos(), hx.CodeInt(`_a.itemAddr(0).load().val.result;`, fut), 42)
+	TEQ(tardisgolib.CPos(), hx.CodeBool(`_a.itemAddr(0).load().val.panic==null;`, fut), true)
+	fut = asyncDouble(-1)
+	TEQ(tardisgolib.CPos(), hx.CodeString(`_a.itemAddr(0).load().val.panic.message;`, fut), "panic: negative")
+}
+
+// an unbuffered send does not complete until a receiver takes the value,
+// while every goroutine waits for the sleeping receiver, which is not a deadlock
+func testRendezvous() {
+	ch := make(chan int)
+	got := make(chan int, 1)
+	var receiving int32
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt32(&receiving, 1)
+		got <- <-ch
+	}()
+	ch <- 42
+	TEQ(tardisgolib.CPos(), atomic.LoadInt32(&receiving), int32(1))
+	TEQ(tardisgolib.CPos(), <-got, 42)
+}
+
+var preemptDone int32
+
+// the loop below spins until another goroutine has run, which it only lets happen when compiled with -preempt, see coretests.sh
+func testPreempt() {
+	if tardisgolib.Host() == "haxe" && !hx.CodeBool("Scheduler.preemptive;") {
+		return
+	}
+	go func() {
+		atomic.StoreInt32(&preemptDone, 1)
+	}()
+	for atomic.LoadInt32(&preemptDone) == 0 {
+	}
+	TEQ(tardisgolib.CPos(), atomic.LoadInt32(&preemptDone), int32(1))
+}
+
+func testUnbufferedChan() {
+	ch := make(chan int)
+	done := make(chan bool)
+	sent := false
+	go func() {
+		ch <- 1
+		sent = true
+		done <- true
+	}()
+	for i := 0; i < 10; i++ {
+		tardisgolib.Gosched()
+	}
+	TEQ(tardisgolib.CPos(), sent, false) // the sender waits for a receiver
+	TEQ(tardisgolib.CPos(), len(ch), 0)
+	TEQ(tardisgolib.CPos(), cap(ch), 0)
+	TEQ(tardisgolib.CPos(), <-ch, 1)
+	<-done
+	TEQ(tardisgolib.CPos(), sent, true)
+
+	select {
+	case ch <- 2:
+		TEQ(tardisgolib.CPos(), "sent with no receiver", "")
+	default:
+	}
+	go func() { done <- (<-ch == 3) }()
+	ok := false
+	for i := 0; i < 10 && !ok; i++ {
+		select {
+		case ch <- 3:
+			ok = true
+		default:
+			tardisgolib.Gosched()
+		}
+	}
+	TEQ(tardisgolib.CPos(), ok, true)
+	TEQ(tardisgolib.CPos(), <-done, true)
+
+	go func() { ch <- 4 }()
+	got := 0
+	for i := 0; i < 10 && got == 0; i++ {
+		select {
+		case got = <-ch:
+		default:
+			tardisgolib.Gosched()
+		}
+	}
+	TEQ(tardisgolib.CPos(), got, 4)
+}
+
+// a select that sends on an unbuffered channel must only do so when a receiver takes the value,
+// which a receiver waiting in a select with other cases may not do
+func testSelectSendPairing() {
+	a, b := make(chan int), make(chan int)
+	got := make(chan int)
+	go func() {
+		select {
+		case v := <-a:
+			got <- v
+		case v := <-b:
+			got <- v
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		tardisgolib.Gosched() // so that the receiver waits in its select
+	}
+	sent := make(chan int, 2)
+	send := func(c chan int, v int) {
+		select {
+		case c <- v:
+			sent <- v
+		default:
+			sent <- 0
+		}
+	}
+	go send(a, 1)
+	go send(b, 2)
+	v := <-got
+	s1, s2 := <-sent, <-sent // neither sender is left waiting
+	TEQ(tardisgolib.CPos(), s1+s2, v)
+	TEQ(tardisgolib.CPos(), s1 == 0 || s2 == 0, true)
+	select {
+	case <-a:
+		TEQ(tardisgolib.CPos(), "stale value received on a", "")
+	case <-b:
+		TEQ(tardisgolib.CPos(), "stale value received on b", "")
+	default:
+	}
+}
+
+func testChanEdgeCases() {
+	var nc chan int
+	TEQ(tardisgolib.CPos(), len(nc), 0)
+	TEQ(tardisgolib.CPos(), cap(nc), 0)
+	select {
+	case nc <- 1:
+		TEQ(tardisgolib.CPos(), "sent on nil channel", "")
+	case <-nc:
+		TEQ(tardisgolib.CPos(), "received from nil channel", "")
+	default:
+	}
+	checkRuntimeError(tardisgolib.CPos(), func() { close(nc) }, "close of nil channel")
+
+	c := make(chan int, 2)
+	c <- 1
+	close(c)
+	checkRuntimeError(tardisgolib.CPos(), func() { close(c) }, "close of closed channel")
+	checkRuntimeError(tardisgolib.CPos(), func() { c <- 2 }, "send on closed channel")
+	checkRuntimeError(tardisgolib.CPos(), func() {
+		select {
+		case c <- 2:
+		default:
+		}
+	}, "send on closed channel")
+	v, ok := <-c
+	TEQ(tardisgolib.CPos(), v, 1)
+	TEQ(tardisgolib.CPos(), ok, true)
+	v, ok = <-c
+	TEQ(tardisgolib.CPos(), v, 0)
+	TEQ(tardisgolib.CPos(), ok, false)
+	select {
+	case v, ok = <-c:
+	default:
+		TEQ(tardisgolib.CPos(), "closed channel not ready", "")
+	}
+	TEQ(tardisgolib.CPos(), ok, false)
+
+	u := make(chan int)
+	done := make(chan bool)
+	go func() {
+		defer func() { done <- recover() != nil }()
+		u <- 1 // waits for a receiver, then panics when the channel is closed
+	}()
+	for i := 0; i < 10; i++ {
+		tardisgolib.Gosched()
+	}
+	close(u)
+	TEQ(tardisgolib.CPos(), <-done, true)
+	_, ok = <-u
+	TEQ(tardisgolib.CPos(), ok, false)
+}
+
 // these two names were failing in java as being duplicates, now failing in PHP...
 func Ilogb(x float64) int {
 	return int(Sqrt(x))
@@ -1436,6 +1830,34 @@ func receiveCakeAndPack(strbry_cs chan string, choco_cs chan string) {
 	}
 }
 
+func testTimers() {
+	order := make(chan int, 3)
+	for _, ms := range []int{30, 10, 20} {
+		go func(ms int) {
+			time.Sleep(time.Duration(ms) * time.Millisecond)
+			order <- ms
+		}(ms)
+	}
+	first, second, third := <-order, <-order, <-order
+	TEQ(tardisgolib.CPos(), first*10000+second*100+third, 102030) // woken in the order they are due
+	never := make(chan bool)
+	select {
+	case <-time.After(10 * time.Millisecond):
+	case <-never:
+		TEQ(tardisgolib.CPos(), "received from never", "")
+	}
+	t := time.NewTimer(time.Hour) // the goroutine running the timer must end when it is stopped, see the goroutine count in main()
+	TEQ(tardisgolib.CPos(), t.Stop(), true)
+	TEQ(tardisgolib.CPos(), t.Stop(), false)
+	t = time.NewTimer(10 * time.Millisecond)
+	t.Stop()
+	select {
+	case <-t.C:
+		TEQ(tardisgolib.CPos(), "stopped timer fired", "")
+	case <-time.After(30 * time.Millisecond):
+	}
+}
+
 func testChanSelect() {
 	strbry_cs := make(chan string)
 	choco_cs := make(chan string)
@@ -1554,6 +1976,428 @@ func testPtr() {
 
 }
 
+// the addresses taken below never leave the function, so may be held as scalars rather than allocated
+func testScalarAlloc() {
+	x := 0
+	p := &x
+	for i := 1; i <= 4; i++ {
+		*p += i
+	}
+	TEQ(tardisgolib.CPos(), x, 10)
+
+	sum := 0
+	for i := 0; i < 3; i++ {
+		var v int // must be zeroed on each iteration
+		q := &v
+		*q += i
+		sum += *q
+	}
+	TEQ(tardisgolib.CPos(), sum, 3)
+
+	var s string
+	var i64 int64
+	var sl []int
+	ps, pi64, psl := &s, &i64, &sl
+	TEQ(tardisgolib.CPos(), *ps, "")
+	TEQ(tardisgolib.CPos(), *pi64 == 0, true)
+	TEQ(tardisgolib.CPos(), *psl == nil, true)
+	*ps += "abc"
+	*pi64 -= 1 << 40
+	*psl = append(*psl, 42)
+	TEQ(tardisgolib.CPos(), s, "abc")
+	TEQ(tardisgolib.CPos(), i64 == -(1<<40), true)
+	TEQ(tardisgolib.CPos(), len(sl), 1)
+}
+
+const constPropDebug = false
+
+func constPropFour() int { return 4 }
+
+// the branches below should be removed at compile time, see tgossa.ConstProp()
+func testConstProp() {
+	y := 0
+	if constPropDebug {
+		y = 100
+	}
+	if constPropFour() == 4 {
+		y += 3
+	} else {
+		y += 200
+	}
+	z := 1 << 3
+	for i := 0; i < z; i++ {
+		y += i
+	}
+	TEQ(tardisgolib.CPos(), y, 31)
+	var i8 int8 = 127
+	TEQ(tardisgolib.CPos(), i8+1, int8(-128)) // overflow is left to the runtime
+	if tardisgolib.Host() == "haxe" {
+		TEQ(tardisgolib.CPos(), tardisgolib.Host(), "haxe")
+	}
+	// the Haxe code in these branches does not compile, so the test program only builds if they have been pruned
+	if constPropFour() != 4 {
+		hx.Code("constPropFourNotFolded();")
+	}
+	if tardisgolib.Host() != "haxe" && tardisgolib.Host() != "go" {
+		hx.Code("hostNotFolded();")
+	}
+}
+
+// the index range checks below can be proved unnecessary, see tgossa.InRangeIndexes()
+func testBoundsCheckElim() {
+	s := []int{1, 2, 3, 4}
+	t := 0
+	for i := range s {
+		t += s[i]
+	}
+	for i := 0; i < len(s); i++ {
+		t += s[i]
+	}
+	var a [3]int
+	for i := range a {
+		a[i] = i
+		t += a[i]
+	}
+	TEQ(tardisgolib.CPos(), t, 23)
+}
+
+// callerInfo gives the function, file and line of a call on the stack, as seen by runtime.Caller(skip)
+//
+//go:noinline
+func callerInfo(skip int) (string, string, int) {
+	pc, file, line, ok := runtime.Caller(skip)
+	if !ok {
+		return "", "", 0
+	}
+	return runtime.FuncForPC(pc).Name(), file, line
+}
+
+// the line numbers of calls are only known when tardisgo compiles with -debug, as the tests are
+func testCaller() {
+	name, _, _ := callerInfo(0)
+	TEQ(tardisgolib.CPos(), name, "main.callerInfo")
+	_, hereFile, here, _ := runtime.Caller(0)
+	name, file, line := callerInfo(1)
+	TEQ(tardisgolib.CPos(), name, "main.testCaller")
+	TEQ(tardisgolib.CPos(), file, hereFile)
+	TEQ(tardisgolib.CPos(), line, here+1)
+	name, _, _ = callerInfo(2)
+	TEQ(tardisgolib.CPos(), name, "main.main")
+	pcs := make([]uintptr, 2)
+	TEQ(tardisgolib.CPos(), runtime.Callers(1, pcs), 2)
+	TEQ(tardisgolib.CPos(), callersName(pcs[0]), "main.testCaller")
+	TEQ(tardisgolib.CPos(), callersName(pcs[1]), "main.main")
+	runtime.Callers(2, pcs)
+	TEQ(tardisgolib.CPos(), callersName(pcs[0]), "main.main")
+	all := make([]uintptr, 100)
+	TEQ(tardisgolib.CPos(), runtime.Callers(0, all), runtime.Callers(1, all)+1) // unlike Caller, skip 0 is Callers itself
+}
+
+// callersName gives the function of a pc from runtime.Callers, under gc this is the return address,
+// which may be the start of the next, perhaps inlined, call
+func callersName(pc uintptr) string {
+	if tardisgolib.Host() == "go" {
+		pc--
+	}
+	return runtime.FuncForPC(pc).Name()
+}
+
+func recursiveSum(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return n + recursiveSum(n-1)
+}
+
+// deep, but finite, recursion must stay within the stack limit, see tests/stackoverflow for the runaway case
+func testDeepRecursion() {
+	TEQ(tardisgolib.CPos(), recursiveSum(1500), 1500*1501/2)
+}
+
+// the leaf functions below are small enough to be inlined when compiled with -inline, see coretests.sh
+func inlineAdd(a, b int) int        { return a + b }
+func inlineElem(s []int, i int) int { return s[i] }
+func inlineQuo(a, b int) int        { return a / b }
+
+//tardisgo:noinline
+func noInlineAdd(a, b int) int { return a + b }
+
+// noInlineElem is the same leaf as inlineElem, but the directive keeps it in a stack frame of its own
+//
+//tardisgo:noinline
+func noInlineElem(s []int, i int) int { return s[i] }
+
+// NoInlinePanic is called from Haxe below, so that the traceback of its panic can be read
+func NoInlinePanic(i int) int { return noInlineElem([]int{1, 2, 3}, i) }
+
+// noInlineFrame gives its own name and the depth of the stack as seen from inside it,
+// it makes calls, so is never a leaf that tardisgo could inline, the directive stops gc inlining it
+//
+//go:noinline
+func noInlineFrame() (string, int) {
+	pc, _, _, _ := runtime.Caller(0)
+	return runtime.FuncForPC(pc).Name(), runtime.Callers(0, make([]uintptr, 100))
+}
+
+func testInline() {
+	TEQ(tardisgolib.CPos(), inlineAdd(2, 3), 5)
+	TEQ(tardisgolib.CPos(), noInlineAdd(2, 3), 5)
+	TEQ(tardisgolib.CPos(), inlineAdd(inlineAdd(1, 2), inlineAdd(3, 4)), 10) // each copy of the body has registers of its own
+	s := []int{1, 2, 3}
+	TEQ(tardisgolib.CPos(), inlineElem(s, 2)+inlineQuo(7, 2), 6)
+	checkRuntimeError(tardisgolib.CPos(), func() { inlineElem(s, 3) }, "runtime error: index out of range")
+	z := 0
+	checkRuntimeError(tardisgolib.CPos(), func() { inlineQuo(1, z) }, "runtime error: integer divide by zero")
+	r := func() (r int) {
+		defer func() {
+			if recover() != nil {
+				r = -1
+			}
+		}()
+		return inlineAdd(1, inlineElem(s, 5))
+	}()
+	TEQ(tardisgolib.CPos(), r, -1) // recovered by the caller of the inlined code
+	name, depth := noInlineFrame()
+	TEQ(tardisgolib.CPos(), name, "main.noInlineFrame")
+	TEQ(tardisgolib.CPos(), depth, runtime.Callers(0, make([]uintptr, 100))+1)
+	if tardisgolib.Host() == "haxe" { // the frame of noInlineElem is in the traceback taken when it panics
+		TEQ(tardisgolib.CPos(), hx.CodeBool(`try{Go_main_NoInlinePanic.callFromHaxe(3);false;}catch(p:GoPanic){p.traceback.indexOf("\tGo_main_noInlineElem ")>=0;}`), true)
+	}
+}
+
+type nilCheckPair struct{ a, b int }
+
+func (p nilCheckPair) sum() int { return p.a + p.b }
+
+type nilCheckSummer interface {
+	sum() int
+}
+
+// calling the value method sum through a pointer uses the (*nilCheckPair).sum wrapper, which checks the pointer with ssa:wrapnilchk,
+// when inlined the check of &v is removed, which coretests.sh checks in the -stats output, see tgossa.IsNilCheckWrapper()
+func testNilCheckElim() {
+	v := nilCheckPair{1, 2}
+	var s nilCheckSummer = &v
+	TEQ(tardisgolib.CPos(), s.sum(), 3)
+	f := (*nilCheckPair).sum
+	TEQ(tardisgolib.CPos(), f(&v), 3)
+	var np *nilCheckPair
+	s = np
+	defer func() {
+		TEQ(tardisgolib.CPos(), recover() != nil, true)
+	}()
+	TEQ(tardisgolib.CPos(), s.sum(), -1) // should not reach here
+}
+
+func testAppendCapacity() {
+	s := make([]int, 3, 10)
+	t := append(s, 4)
+	TEQ(tardisgolib.CPos(), cap(t), 10)
+	TEQ(tardisgolib.CPos(), s[:4][3], 4) // append wrote into the spare capacity of the shared array
+	x := []int{1, 2, 3, 4, 5}
+	y := append(x[:1], x[2:]...) // overlapping source and destination
+	TEQ(tardisgolib.CPos(), len(y), 4)
+	TEQ(tardisgolib.CPos(), y[1]*100+y[2]*10+y[3], 345)
+	TEQ(tardisgolib.CPos(), x[4], 5)
+	var n []int
+	for i := 0; i < 100; i++ {
+		n = append(n, i)
+	}
+	TEQ(tardisgolib.CPos(), len(n), 100)
+	TEQ(tardisgolib.CPos(), cap(n) >= 100 && cap(n) < 200, true)
+	TEQ(tardisgolib.CPos(), n[99], 99)
+	m := append([]int(nil), n[:2]...) // must not alias n
+	m[0] = 42
+	TEQ(tardisgolib.CPos(), n[0], 0)
+	c := []int{1, 2, 3}
+	copy(c[1:], c) // overlapping copy
+	TEQ(tardisgolib.CPos(), c[0]*100+c[1]*10+c[2], 112)
+}
+
+func testFullSlice() {
+	s := make([]int, 5, 10)
+	t := s[1:3:4]
+	TEQ(tardisgolib.CPos(), len(t), 2)
+	TEQ(tardisgolib.CPos(), cap(t), 3)
+	u := append(t, 7, 8) // exceeds the limited capacity, so must not overwrite s
+	TEQ(tardisgolib.CPos(), s[4], 0)
+	TEQ(tardisgolib.CPos(), u[3], 8)
+	var a [6]int
+	v := a[2:4:5]
+	TEQ(tardisgolib.CPos(), cap(v), 3)
+	v = append(v, 9)
+	TEQ(tardisgolib.CPos(), a[4], 9) // within the limited capacity, so shares the array
+	defer func() {
+		TEQ(tardisgolib.CPos(), recover() != nil, true)
+	}()
+	m := 2
+	w := s[1:3:m]                       // max < high, so panics
+	TEQ(tardisgolib.CPos(), len(w), -1) // should not reach here
+}
+
+type mapKeyT struct {
+	a int
+	b string
+	_ int
+	c [2]float64
+}
+
+func testMapKeys() {
+	ms := make(map[mapKeyT]int)
+	ms[mapKeyT{a: 1, b: "x"}] = 1
+	k := mapKeyT{a: 1, b: "x"}
+	ms[k]++
+	TEQ(tardisgolib.CPos(), len(ms), 1)
+	TEQ(tardisgolib.CPos(), ms[mapKeyT{a: 1, b: "x"}], 2)
+	k.a = 2 // the key held in the map must not change
+	TEQ(tardisgolib.CPos(), ms[mapKeyT{a: 1, b: "x"}], 2)
+	_, ok := ms[k]
+	TEQ(tardisgolib.CPos(), ok, false)
+
+	m64 := make(map[int64]string)
+	m64[1<<40] = "big"
+	m64[1] = "small"
+	TEQ(tardisgolib.CPos(), m64[1<<40], "big")
+	delete(m64, 1)
+	TEQ(tardisgolib.CPos(), len(m64), 1)
+
+	mf := make(map[float64]int)
+	zero := 0.0
+	nan := zero / zero
+	mf[nan] = 1
+	mf[nan] = 2 // NaN != NaN, so each is a new key
+	mf[zero] = 3
+	mf[-zero] = 4 // +0 == -0
+	TEQ(tardisgolib.CPos(), len(mf), 3)
+	TEQ(tardisgolib.CPos(), mf[0], 4)
+
+	mi := make(map[interface{}]int)
+	mi[1] = 1
+	mi["1"] = 2
+	mi[mapKeyT{a: 3}] = 3
+	mi[mapKeyT{a: 3}] += 10
+	TEQ(tardisgolib.CPos(), len(mi), 3)
+	TEQ(tardisgolib.CPos(), mi[mapKeyT{a: 3}], 13)
+	TEQ(tardisgolib.CPos(), mi[1], 1)
+
+	var arr [3]int
+	mp := make(map[*int]bool)
+	mp[&arr[1]] = true
+	TEQ(tardisgolib.CPos(), mp[&arr[1]], true)
+	TEQ(tardisgolib.CPos(), mp[&arr[2]], false)
+
+	defer func() {
+		TEQ(tardisgolib.CPos(), recover() != nil, true)
+	}()
+	mi[[]int{1}] = 4                     // slices are not comparable, so panics
+	TEQ(tardisgolib.CPos(), len(mi), -1) // should not reach here
+}
+
+func testMapRange() {
+	m := make(map[int]int)
+	for i := 0; i < 10; i++ {
+		m[i] = i
+	}
+	n := 0
+	for k := range m {
+		for j := range m { // delete every other entry on the first iteration
+			if j != k {
+				delete(m, j)
+			}
+		}
+		n++
+	}
+	TEQ(tardisgolib.CPos(), n, 1)
+	TEQ(tardisgolib.CPos(), len(m), 1)
+	ms := map[string]bool{"a": true, "b": true}
+	for k := range ms {
+		ms[k+k] = true // inserting during a range is allowed
+	}
+	TEQ(tardisgolib.CPos(), ms["a"], true)
+	TEQ(tardisgolib.CPos(), len(ms) >= 3, true)
+	mf := map[float64]int{1: 1}
+	mf[math.NaN()] = 2 // NaN keys never match, so each is a new entry which can only be reached by range
+	mf[math.NaN()] = 3
+	nans, sum := 0, 0
+	for k, v := range mf {
+		if k != k {
+			nans++
+		}
+		sum += v
+	}
+	TEQ(tardisgolib.CPos(), nans, 2)
+	TEQ(tardisgolib.CPos(), sum, 6)
+}
+
+type eqA int
+type eqB int
+
+type eqS struct {
+	i  int
+	f  float64
+	x  interface{}
+	ar [2]string
+}
+
+func testEquality() {
+	var ia, ib interface{} = eqA(1), eqB(1)
+	TEQ(tardisgolib.CPos(), ia == ib, false) // different dynamic types
+	ib = eqA(1)
+	TEQ(tardisgolib.CPos(), ia == ib, true)
+	s1 := eqS{1, 2.5, "x", [2]string{"a", "b"}}
+	s2 := eqS{1, 2.5, "x", [2]string{"a", "b"}}
+	TEQ(tardisgolib.CPos(), s1 == s2, true)
+	s2.ar[1] = "c"
+	TEQ(tardisgolib.CPos(), s1 != s2, true)
+	var is1, is2 interface{} = s1, eqS{1, 2.5, "x", [2]string{"a", "b"}}
+	TEQ(tardisgolib.CPos(), is1 == is2, true)
+	var arr [4]int
+	p1, p2 := &arr[2], &arr[2]
+	TEQ(tardisgolib.CPos(), p1 == p2, true)
+	TEQ(tardisgolib.CPos(), p1 == &arr[1], false)
+	defer func() {
+		TEQ(tardisgolib.CPos(), recover() != nil, true)
+	}()
+	var sl1, sl2 interface{} = []int{1}, []int{1}
+	TEQ(tardisgolib.CPos(), sl1 == sl2, false) // should panic before here
+}
+
+// runtimeError has the same method set as runtime.Error
+type runtimeError interface {
+	error
+	RuntimeError()
+}
+
+func checkRuntimeError(pos string, f func(), want string) {
+	defer func() {
+		re, ok := recover().(runtimeError)
+		TEQ(pos, ok, true)
+		if ok {
+			msg := re.Error()
+			TEQ(pos, len(msg) >= len(want) && msg[:len(want)] == want, true)
+		}
+	}()
+	f()
+}
+
+func testRuntimeErrors() {
+	s := []int{1}
+	i := 2
+	checkRuntimeError(tardisgolib.CPos(), func() { s[i] = 3 }, "runtime error: index out of range")
+	z := 0
+	checkRuntimeError(tardisgolib.CPos(), func() { i /= z }, "runtime error: integer divide by zero")
+	var e interface{} = "s"
+	checkRuntimeError(tardisgolib.CPos(), func() { i = e.(int) }, "interface conversion: ")
+	var m map[string]int // nil maps can be read from, but not written to
+	TEQ(tardisgolib.CPos(), m["a"], 0)
+	TEQ(tardisgolib.CPos(), len(m), 0)
+	for k := range m {
+		TEQ(tardisgolib.CPos(), "nil map has key "+k, "")
+	}
+	delete(m, "a")
+	checkRuntimeError(tardisgolib.CPos(), func() { m["a"] = 1 }, "assignment to entry in nil map")
+}
+
 func main() {
 	var array [4][5]int
 	array[3][2] = 12
@@ -1570,6 +2414,12 @@ func main() {
 	testFloat()
 	testMultiRet()
 	testAppend()
+	testAppendCapacity()
+	testFullSlice()
+	testMapKeys()
+	testMapRange()
+	testEquality()
+	testRuntimeErrors()
 	testStruct()
 	testHeader()
 	testCopy()
@@ -1595,8 +2445,27 @@ func main() {
 	testUintDiv32()
 	testUintDiv64()
 	testDefer()
+	testPanicRecover()
+	testGoexit()
+	testHaxeException()
+	testHostCallPanic()
+	testHostCallReentry()
+	testGoFuture()
+	testUnbufferedChan()
+	testSelectSendPairing()
+	testRendezvous()
+	testPreempt()
+	testChanEdgeCases()
 	testPtr()
+	testScalarAlloc()
+	testConstProp()
+	testBoundsCheckElim()
+	testInline()
+	testDeepRecursion()
+	testCaller()
+	testNilCheckElim()
 	testChanSelect()
+	testTimers()
 	//aGrWG.Wait()
 	TEQint32(tardisgolib.CPos()+" testManyGoroutines() (NOT sync/atomic) counter:", aGrCtr, 0)
 	if tardisgolib.Host() == "haxe" {
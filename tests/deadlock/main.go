@@ -0,0 +1,12 @@
+// This program must fail with the "all goroutines are asleep" deadlock error,
+// followed by the state and stack of each waiting goroutine, as with gc, see coretests.sh
+package main
+
+func waitForever(c chan int) {
+	<-c // never sent
+}
+
+func main() {
+	go waitForever(make(chan int))
+	make(chan int) <- 1 // never received
+}
@@ -0,0 +1,17 @@
+// A host that runs the Go code in main.go using Go.pump(),
+// then calls the Go code that lets the Go main function continue once all the goroutines are waiting
+import tardis.Go;
+
+class Host {
+	static function main() {
+		var fed:Bool=false;
+		while(Go.pump(Go.asyncSlice)) {
+			if(Scheduler.idling && !fed) {
+				fed=true;
+				Go_main_Feed.callAsync(42);
+			}
+		}
+		if(!fed)
+			trace("the Go main function did not wait for the host");
+	}
+}
@@ -0,0 +1,17 @@
+// The Go main function of this program waits for a value that the Haxe host in Host.hx sends once the goroutines are all waiting,
+// which must not be reported as a deadlock, see coretests.sh
+package main
+
+var fromHost = make(chan int)
+
+// Feed is called from Haxe, using callAsync()
+func Feed(v int) {
+	fromHost <- v
+}
+
+func main() {
+	if v := <-fromHost; v != 42 {
+		panic("wrong value from the host")
+	}
+	println("received from host")
+}
@@ -0,0 +1,13 @@
+// This program must fail with the "all goroutines are asleep" deadlock error, as it does with gc,
+// because a goroutine remains after main calls runtime.Goexit, see coretests.sh
+package main
+
+import "runtime"
+
+func main() {
+	c := make(chan int)
+	go func() {
+		<-c // never sent
+	}()
+	runtime.Goexit()
+}
@@ -0,0 +1,10 @@
+// This program must fail with the Go-style stack overflow error, rather than a crash of the host, see coretests.sh
+package main
+
+func recurse(n int) int {
+	return recurse(n+1) + 1
+}
+
+func main() {
+	println(recurse(0))
+}
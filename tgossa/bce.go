@@ -0,0 +1,185 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package tgossa
+
+import (
+	"go/token"
+
+	"code.google.com/p/go.tools/go/exact"
+	"code.google.com/p/go.tools/go/ssa"
+	"code.google.com/p/go.tools/go/types"
+)
+
+// InRangeIndexes returns the Index and IndexAddr instructions in the function whose index can be proved to be in range,
+// so that they do not require a bounds check. The cases found are:
+// constant indexes into arrays; and indexes that are both non-negative, and less than the length of the
+// value being indexed because of a dominating if-statement, which covers the usual loop forms:
+//
+//	for i := range s { ... s[i] ... }
+//	for i := 0; i < len(s); i++ { ... s[i] ... }
+func InRangeIndexes(fn *ssa.Function) map[ssa.Instruction]bool {
+	ret := make(map[ssa.Instruction]bool)
+	for _, b := range fn.Blocks {
+		for _, in := range b.Instrs {
+			var x, idx ssa.Value
+			switch in.(type) {
+			case *ssa.Index:
+				x, idx = in.(*ssa.Index).X, in.(*ssa.Index).Index
+			case *ssa.IndexAddr:
+				x, idx = in.(*ssa.IndexAddr).X, in.(*ssa.IndexAddr).Index
+			default:
+				continue
+			}
+			aLen := arrayLen(x.Type())
+			if c, ok := idx.(*ssa.Const); ok && aLen >= 0 {
+				if i, ok := constInt(c); ok && i >= 0 && i < aLen {
+					ret[in] = true
+				}
+				continue
+			}
+			if nonNegIndex(idx) && lessThanLen(idx, x, aLen, b) {
+				ret[in] = true
+			}
+		}
+	}
+	return ret
+}
+
+// arrayLen returns the length of an array or pointer to array type, or -1 for other types.
+func arrayLen(t types.Type) int64 {
+	t = t.Underlying()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem().Underlying()
+	}
+	if a, ok := t.(*types.Array); ok {
+		return a.Len()
+	}
+	return -1
+}
+
+func constInt(c *ssa.Const) (int64, bool) {
+	if c.Value == nil || c.Value.Kind() != exact.Int {
+		return 0, false
+	}
+	return exact.Int64Val(c.Value)
+}
+
+// nonNegIndex returns true if v can be proved to be >= 0.
+func nonNegIndex(v ssa.Value) bool {
+	switch v.(type) {
+	case *ssa.Const:
+		i, ok := constInt(v.(*ssa.Const))
+		return ok && i >= 0
+	case *ssa.Phi: // for i := 0; i < n; i++ { ... }
+		return inductionFrom(v.(*ssa.Phi), v, 0)
+	case *ssa.BinOp: // for i := range s { ... }, where the index is the incremented value
+		bo := v.(*ssa.BinOp)
+		if phi, ok := bo.X.(*ssa.Phi); ok && isIncrement(bo, phi) {
+			return inductionFrom(phi, bo, -1)
+		}
+	}
+	return false
+}
+
+// isIncrement is true if v is x+1.
+func isIncrement(v *ssa.BinOp, x ssa.Value) bool {
+	if v.Op != token.ADD || v.X != x {
+		return false
+	}
+	c, ok := v.Y.(*ssa.Const)
+	if !ok {
+		return false
+	}
+	i, ok := constInt(c)
+	return ok && i == 1
+}
+
+// inductionFrom returns true if the phi is an induction variable, which starts at a constant >= min,
+// and where each other incoming value is next, which is the phi incremented by 1.
+// The value that is carried around the loop must be guarded by a less-than test, so that the increment cannot overflow.
+func inductionFrom(phi *ssa.Phi, next ssa.Value, min int64) bool {
+	hadInit, hadNext := false, false
+	for e, edge := range phi.Edges {
+		if c, ok := edge.(*ssa.Const); ok {
+			i, ok := constInt(c)
+			if !ok || i < min {
+				return false
+			}
+			hadInit = true
+			continue
+		}
+		if next == ssa.Value(phi) { // the phi is the index, so the edge must be phi+1
+			bo, ok := edge.(*ssa.BinOp)
+			if !ok || !isIncrement(bo, phi) || !guardedLess(phi, nil, bo.Block()) {
+				return false
+			}
+		} else { // the incremented value is the index, so it is fed back into the phi
+			if edge != next || !guardedLess(next, nil, phi.Block().Preds[e]) {
+				return false
+			}
+		}
+		hadNext = true
+	}
+	return hadInit && hadNext
+}
+
+// lessThanLen returns true if idx is less than the length of x whenever block b is executed.
+func lessThanLen(idx, x ssa.Value, aLen int64, b *ssa.BasicBlock) bool {
+	return guardedLess(idx, func(bound ssa.Value) bool {
+		if c, ok := bound.(*ssa.Const); ok {
+			i, ok := constInt(c)
+			return ok && aLen >= 0 && i <= aLen
+		}
+		if call, ok := bound.(*ssa.Call); ok && !call.Call.IsInvoke() && len(call.Call.Args) == 1 {
+			if bi, isBuiltin := call.Call.Value.(*ssa.Builtin); isBuiltin && bi.Name() == "len" {
+				return call.Call.Args[0] == x
+			}
+		}
+		return false
+	}, b)
+}
+
+// guardedLess returns true if block b is only executed after an if-statement has found that v < bound,
+// where bound satisfies okBound (or any bound if okBound is nil).
+func guardedLess(v ssa.Value, okBound func(ssa.Value) bool, b *ssa.BasicBlock) bool {
+	for d := b; d != nil; d = d.Idom() {
+		if len(d.Preds) != 1 {
+			continue
+		}
+		p := d.Preds[0]
+		if len(p.Succs) != 2 || p.Succs[0] == p.Succs[1] || len(p.Instrs) == 0 {
+			continue
+		}
+		ifInstr, ok := p.Instrs[len(p.Instrs)-1].(*ssa.If)
+		if !ok {
+			continue
+		}
+		cond, ok := ifInstr.Cond.(*ssa.BinOp)
+		if !ok || !isSignedInt(cond.X.Type()) {
+			continue
+		}
+		isTrue := d == p.Succs[0]
+		var bound ssa.Value
+		switch {
+		case isTrue && cond.Op == token.LSS && cond.X == v, // v < bound
+			!isTrue && cond.Op == token.GEQ && cond.X == v: // !(v >= bound)
+			bound = cond.Y
+		case isTrue && cond.Op == token.GTR && cond.Y == v, // bound > v
+			!isTrue && cond.Op == token.LEQ && cond.Y == v: // !(bound <= v)
+			bound = cond.X
+		default:
+			continue
+		}
+		if okBound == nil || okBound(bound) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSignedInt(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsInteger != 0 && b.Info()&types.IsUnsigned == 0
+}
@@ -0,0 +1,361 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package tgossa
+
+import (
+	"go/token"
+
+	"code.google.com/p/go.tools/go/exact"
+	"code.google.com/p/go.tools/go/ssa"
+	"code.google.com/p/go.tools/go/types"
+)
+
+// ConstProp folds constant expressions in the function, replaces calls to functions that return a constant with that constant,
+// then removes the branches of constant if-statements that can never be taken, along with any blocks that become unreachable.
+// The callConst function gives the constant result of calling a function, or nil if the result is not known to be constant,
+// ConstResult can be used for this, but it allows the caller to both add to and veto the constant functions.
+// Returns the number of values folded and blocks pruned.
+func ConstProp(fn *ssa.Function, callConst func(*ssa.Function) *ssa.Const) (folded, pruned int) {
+	if len(fn.Blocks) == 0 {
+		return 0, 0
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range fn.Blocks {
+			dead := make(map[ssa.Instruction]bool)
+			for _, in := range b.Instrs {
+				if c := foldInstr(in, callConst); c != nil {
+					replaceAll(in.(ssa.Value), c)
+					removeInstr(in)
+					dead[in] = true
+					folded++
+					changed = true
+				}
+			}
+			if len(dead) > 0 {
+				instrs := make([]ssa.Instruction, 0, len(b.Instrs)-len(dead))
+				for _, in := range b.Instrs {
+					if !dead[in] {
+						instrs = append(instrs, in)
+					}
+				}
+				b.Instrs = instrs
+			}
+		}
+		for _, b := range fn.Blocks {
+			if pruneIf(b) {
+				changed = true
+			}
+		}
+		if n := removeUnreachable(fn); n > 0 {
+			pruned += n
+			changed = true
+		}
+	}
+	return folded, pruned
+}
+
+// ConstResult returns the constant that the function always returns, or nil.
+// Only functions whose body is simply "return <constant>" qualify.
+func ConstResult(fn *ssa.Function) *ssa.Const {
+	if len(fn.Blocks) != 1 || fn.Recover != nil || fn.Signature.Results().Len() != 1 {
+		return nil
+	}
+	var ret *ssa.Const
+	for _, in := range fn.Blocks[0].Instrs {
+		switch in.(type) {
+		case *ssa.DebugRef:
+		case *ssa.Return:
+			c, ok := in.(*ssa.Return).Results[0].(*ssa.Const)
+			if !ok {
+				return nil
+			}
+			ret = c
+		default:
+			return nil
+		}
+	}
+	return ret
+}
+
+// foldInstr returns the constant value of the instruction, or nil if it cannot be folded.
+func foldInstr(in ssa.Instruction, callConst func(*ssa.Function) *ssa.Const) *ssa.Const {
+	switch in.(type) {
+	case *ssa.BinOp:
+		bo := in.(*ssa.BinOp)
+		x, xOK := bo.X.(*ssa.Const)
+		y, yOK := bo.Y.(*ssa.Const)
+		if !xOK || !yOK || !foldableType(x.Type()) || !foldableType(y.Type()) {
+			return nil
+		}
+		switch bo.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return ssa.NewConst(exact.MakeBool(exact.Compare(x.Value, bo.Op, y.Value)), bo.Type())
+		case token.SHL, token.SHR:
+			s, ok := exact.Uint64Val(y.Value)
+			if !ok || s > 64 {
+				return nil
+			}
+			return representable(exactShift(x.Value, bo.Op, uint(s)), bo.Type())
+		case token.QUO, token.REM:
+			if exact.Sign(y.Value) == 0 {
+				return nil // leave the runtime to panic
+			}
+			op := bo.Op
+			if op == token.QUO && x.Value.Kind() == exact.Int {
+				op = token.QUO_ASSIGN // forces integer division
+			}
+			return representable(exact.BinaryOp(x.Value, op, y.Value), bo.Type())
+		default:
+			return representable(exact.BinaryOp(x.Value, bo.Op, y.Value), bo.Type())
+		}
+	case *ssa.UnOp:
+		uo := in.(*ssa.UnOp)
+		x, ok := uo.X.(*ssa.Const)
+		if !ok || !foldableType(x.Type()) {
+			return nil
+		}
+		switch uo.Op {
+		case token.NOT, token.SUB: // ^ is not folded, as its result depends on the size of the type
+			return representable(exact.UnaryOp(uo.Op, x.Value, 0), uo.Type())
+		}
+	case *ssa.Phi:
+		var c *ssa.Const
+		for _, e := range in.(*ssa.Phi).Edges {
+			ec, ok := e.(*ssa.Const)
+			if !ok || !foldableType(ec.Type()) ||
+				(c != nil && (c.Value.Kind() != ec.Value.Kind() || !exact.Compare(c.Value, token.EQL, ec.Value))) {
+				return nil
+			}
+			c = ec
+		}
+		if c != nil {
+			return ssa.NewConst(c.Value, in.(*ssa.Phi).Type())
+		}
+	case *ssa.Call:
+		cc := in.(*ssa.Call).Call
+		if cc.IsInvoke() {
+			return nil
+		}
+		if callee := cc.StaticCallee(); callee != nil {
+			if c := callConst(callee); c != nil && c.Value != nil {
+				return ssa.NewConst(c.Value, in.(*ssa.Call).Type())
+			}
+		}
+	}
+	return nil
+}
+
+func exactShift(x exact.Value, op token.Token, s uint) exact.Value {
+	if x.Kind() != exact.Int {
+		return nil
+	}
+	return exact.Shift(x, op, s)
+}
+
+// foldableType is true for the boolean, string and integer types, other than uintptr,
+// floating point is not folded, to avoid differences in precision between the compiler and the target.
+func foldableType(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return false
+	}
+	if b.Info()&(types.IsBoolean|types.IsString|types.IsInteger) == 0 {
+		return false
+	}
+	switch b.Kind() {
+	case types.Uintptr, types.UnsafePointer, types.UntypedNil:
+		return false
+	}
+	return true
+}
+
+// representable returns a constant of type t for the value v, or nil if v will not fit in t.
+// NOTE int and uint are 32 bits in the target languages, so a value that overflows 32 bits is not folded,
+// leaving the target to get the same answer as it would at runtime.
+func representable(v exact.Value, t types.Type) *ssa.Const {
+	if v == nil || v.Kind() == exact.Unknown {
+		return nil
+	}
+	b := t.Underlying().(*types.Basic)
+	if b.Info()&types.IsInteger != 0 {
+		if v.Kind() != exact.Int {
+			return nil
+		}
+		var min, max int64
+		switch b.Kind() {
+		case types.Int8:
+			min, max = -1<<7, 1<<7-1
+		case types.Int16:
+			min, max = -1<<15, 1<<15-1
+		case types.Int, types.Int32, types.UntypedInt, types.UntypedRune:
+			min, max = -1<<31, 1<<31-1
+		case types.Int64:
+			if _, ok := exact.Int64Val(v); !ok {
+				return nil
+			}
+			return ssa.NewConst(v, t)
+		case types.Uint8:
+			min, max = 0, 1<<8-1
+		case types.Uint16:
+			min, max = 0, 1<<16-1
+		case types.Uint, types.Uint32:
+			min, max = 0, 1<<32-1
+		case types.Uint64:
+			if _, ok := exact.Uint64Val(v); !ok {
+				return nil
+			}
+			return ssa.NewConst(v, t)
+		default:
+			return nil
+		}
+		i, ok := exact.Int64Val(v)
+		if !ok || i < min || i > max {
+			return nil
+		}
+	}
+	return ssa.NewConst(v, t)
+}
+
+// replaceAll replaces every use of v with c.
+func replaceAll(v ssa.Value, c *ssa.Const) {
+	refs := v.Referrers()
+	if refs == nil {
+		return
+	}
+	for _, r := range *refs {
+		for _, op := range r.Operands(nil) {
+			if *op == v {
+				*op = c
+			}
+		}
+	}
+	*refs = nil
+}
+
+// removeInstr removes the instruction from the referrers of its operands,
+// the caller must remove it from the block.
+func removeInstr(in ssa.Instruction) {
+	for _, op := range in.Operands(nil) {
+		if *op == nil {
+			continue
+		}
+		refs := (*op).Referrers()
+		if refs == nil {
+			continue
+		}
+		keep := (*refs)[:0]
+		for _, r := range *refs {
+			if r != in {
+				keep = append(keep, r)
+			}
+		}
+		*refs = keep
+	}
+}
+
+// pruneIf makes an if-statement with a constant condition have only the successor that will be taken,
+// pogo then emits it as a jump.
+func pruneIf(b *ssa.BasicBlock) bool {
+	if len(b.Instrs) == 0 || len(b.Succs) != 2 {
+		return false
+	}
+	ifInstr, ok := b.Instrs[len(b.Instrs)-1].(*ssa.If)
+	if !ok {
+		return false
+	}
+	c, ok := ifInstr.Cond.(*ssa.Const)
+	if !ok || c.Value == nil || c.Value.Kind() != exact.Bool {
+		return false
+	}
+	live, dead := b.Succs[0], b.Succs[1]
+	if !exact.BoolVal(c.Value) {
+		live, dead = dead, live
+	}
+	removeEdge(b, dead)
+	b.Succs = []*ssa.BasicBlock{live}
+	return true
+}
+
+// removeEdge removes the control-flow edge from->to from the predecessors of to, along with the matching phi edges.
+func removeEdge(from, to *ssa.BasicBlock) {
+	for p := len(to.Preds) - 1; p >= 0; p-- {
+		if to.Preds[p] == from {
+			to.Preds = append(to.Preds[:p:p], to.Preds[p+1:]...)
+			for _, in := range to.Instrs {
+				phi, ok := in.(*ssa.Phi)
+				if !ok {
+					break // phis are always at the start of a block
+				}
+				if e := phi.Edges[p]; e != nil {
+					if refs := e.Referrers(); refs != nil && !usedElsewhere(phi, p) {
+						keep := (*refs)[:0]
+						for _, r := range *refs {
+							if r != ssa.Instruction(phi) {
+								keep = append(keep, r)
+							}
+						}
+						*refs = keep
+					}
+				}
+				phi.Edges = append(phi.Edges[:p:p], phi.Edges[p+1:]...)
+			}
+			return
+		}
+	}
+}
+
+// usedElsewhere is true if the value of edge p of the phi is also used by another of its edges.
+func usedElsewhere(phi *ssa.Phi, p int) bool {
+	for e := range phi.Edges {
+		if e != p && phi.Edges[e] == phi.Edges[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// removeUnreachable removes those blocks that can no longer be reached from the entry block (or the recover block),
+// returning the number removed.
+func removeUnreachable(fn *ssa.Function) int {
+	reachable := make(map[*ssa.BasicBlock]bool)
+	var mark func(b *ssa.BasicBlock)
+	mark = func(b *ssa.BasicBlock) {
+		if !reachable[b] {
+			reachable[b] = true
+			for _, s := range b.Succs {
+				mark(s)
+			}
+		}
+	}
+	mark(fn.Blocks[0])
+	if fn.Recover != nil {
+		mark(fn.Recover)
+	}
+	if len(reachable) == len(fn.Blocks) {
+		return 0
+	}
+	live := make([]*ssa.BasicBlock, 0, len(reachable))
+	for _, b := range fn.Blocks {
+		if reachable[b] {
+			live = append(live, b)
+			continue
+		}
+		for _, s := range b.Succs {
+			if reachable[s] {
+				removeEdge(b, s)
+			}
+		}
+		for _, in := range b.Instrs {
+			removeInstr(in)
+		}
+	}
+	removed := len(fn.Blocks) - len(live)
+	for i, b := range live {
+		b.Index = i
+	}
+	fn.Blocks = live
+	return removed
+}
@@ -0,0 +1,99 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package tgossa
+
+import (
+	"go/token"
+
+	"code.google.com/p/go.tools/go/ssa"
+	"code.google.com/p/go.tools/go/types"
+)
+
+// NonEscapingAllocs returns the set of Alloc instructions in the function whose address never leaves it,
+// that is the address (or the address of any part of the allocated value) is only ever
+// loaded from, stored to or compared; it is never stored, passed, returned, converted or captured.
+func NonEscapingAllocs(fn *ssa.Function) map[*ssa.Alloc]bool {
+	ret := make(map[*ssa.Alloc]bool)
+	for _, b := range fn.Blocks {
+		for _, in := range b.Instrs {
+			if a, ok := in.(*ssa.Alloc); ok && !addrEscapes(a) {
+				ret[a] = true
+			}
+		}
+	}
+	return ret
+}
+
+// addrEscapes returns true if the address value v may be used outside of the current function.
+func addrEscapes(v ssa.Value) bool {
+	refs := v.Referrers()
+	if refs == nil {
+		return true
+	}
+	for _, r := range *refs {
+		switch r.(type) {
+		case *ssa.DebugRef:
+			// not code
+		case *ssa.Store:
+			if r.(*ssa.Store).Val == v { // the address itself is being stored somewhere
+				return true
+			}
+		case *ssa.UnOp:
+			if r.(*ssa.UnOp).Op != token.MUL {
+				return true
+			}
+		case *ssa.BinOp:
+			switch r.(*ssa.BinOp).Op {
+			case token.EQL, token.NEQ:
+			default:
+				return true
+			}
+		case *ssa.FieldAddr, *ssa.IndexAddr:
+			if addrEscapes(r.(ssa.Value)) {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// ScalarAllocs returns the subset of NonEscapingAllocs that hold a single non-aggregate value
+// which is only ever directly stored to or loaded from, so can be held in a local variable of the element type
+// rather than in an allocated object accessed via a pointer.
+func ScalarAllocs(fn *ssa.Function) map[*ssa.Alloc]bool {
+	ret := make(map[*ssa.Alloc]bool)
+	for a := range NonEscapingAllocs(fn) {
+		if isScalar(a) {
+			ret[a] = true
+		}
+	}
+	return ret
+}
+
+func isScalar(a *ssa.Alloc) bool {
+	switch a.Type().Underlying().(*types.Pointer).Elem().Underlying().(type) {
+	case *types.Array, *types.Struct, *types.Tuple:
+		return false
+	case *types.Basic:
+		if a.Type().Underlying().(*types.Pointer).Elem().Underlying().(*types.Basic).Kind() == types.UnsafePointer {
+			return false // unsafe pointers have special handling in the target languages
+		}
+	}
+	for _, r := range *a.Referrers() {
+		switch r.(type) {
+		case *ssa.DebugRef:
+		case *ssa.Store:
+			if r.(*ssa.Store).Addr != a {
+				return false
+			}
+		case *ssa.UnOp: // only a load, given that the address does not escape
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package tgossa
+
+import (
+	"go/token"
+	"io/ioutil"
+	"strings"
+
+	"code.google.com/p/go.tools/go/ssa"
+)
+
+// NoInlineDirectives are the comments that, when placed on the line(s) directly above a function declaration,
+// stop that function from being inlined.
+var NoInlineDirectives = []string{"//tardisgo:noinline", "//go:noinline"}
+
+// InlineCandidates returns the set of functions whose bodies may be copied into their callers at the point of call,
+// rather than being called via a new stack frame.
+// Only small straight-line leaf functions that do not use goroutines are candidates,
+// threshold gives the maximum number of instructions in a candidate, a threshold <= 0 disables inlining.
+// The exclude function allows the caller to veto functions that the target language handles specially.
+func InlineCandidates(fns, usesGR map[*ssa.Function]bool, threshold int, exclude func(*ssa.Function) bool) map[*ssa.Function]bool {
+	ret := make(map[*ssa.Function]bool)
+	if threshold <= 0 {
+		return ret
+	}
+	for fn := range fns {
+		if !usesGR[fn] && canInline(fn, threshold) && !exclude(fn) && !hasDirective(fn, NoInlineDirectives) {
+			ret[fn] = true
+		}
+	}
+	return ret
+}
+
+// canInline checks that the function is a single block, with no calls except to builtins,
+// no free variables, no recover block, at most one result and no more than threshold instructions.
+func canInline(fn *ssa.Function, threshold int) bool {
+	if len(fn.Blocks) != 1 || fn.Recover != nil || len(fn.FreeVars) > 0 ||
+		fn.Signature.Results().Len() > 1 {
+		return false
+	}
+	instrs := fn.Blocks[0].Instrs
+	if len(instrs) == 0 {
+		return false
+	}
+	if _, isRet := instrs[len(instrs)-1].(*ssa.Return); !isRet {
+		return false
+	}
+	count := 0
+	for _, in := range instrs {
+		switch in.(type) {
+		case *ssa.DebugRef:
+			continue // not code
+		case *ssa.Go, *ssa.Defer, *ssa.RunDefers, *ssa.Panic, *ssa.Send, *ssa.Select, *ssa.Phi:
+			return false
+		case *ssa.UnOp:
+			if in.(*ssa.UnOp).Op == token.ARROW {
+				return false
+			}
+		case *ssa.Call:
+			if in.(*ssa.Call).Call.IsInvoke() {
+				return false
+			}
+			bi, isBuiltin := in.(*ssa.Call).Call.Value.(*ssa.Builtin)
+			if !isBuiltin || bi.Name() == "recover" {
+				return false
+			}
+		}
+		count++
+	}
+	return count <= threshold
+}
+
+var sourceLines = make(map[string][]string) // cache of source files read when looking for directives
+
+// hasDirective returns true if one of the given directive comments appears in the comment block
+// directly above the declaration of the function.
+func hasDirective(fn *ssa.Function, directives []string) bool {
+	if fn.Prog == nil || !fn.Pos().IsValid() {
+		return false
+	}
+	pos := fn.Prog.Fset.Position(fn.Pos())
+	lines, ok := sourceLines[pos.Filename]
+	if !ok {
+		src, err := ioutil.ReadFile(pos.Filename)
+		if err == nil {
+			lines = strings.Split(string(src), "\n")
+		}
+		sourceLines[pos.Filename] = lines // NOTE a nil entry records that the file could not be read
+	}
+	for l := pos.Line - 2; l >= 0 && l < len(lines); l-- { // pos.Line counts from 1, so start on the line above
+		line := strings.TrimSpace(lines[l])
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		for _, d := range directives {
+			if strings.HasPrefix(line, d) {
+				return true
+			}
+		}
+	}
+	return false
+}
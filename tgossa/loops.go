@@ -0,0 +1,20 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package tgossa
+
+import (
+	"code.google.com/p/go.tools/go/ssa"
+)
+
+// HasBackEdge returns true if block b may jump back to the head of a loop that contains it,
+// that is to a successor block that dominates b.
+func HasBackEdge(b *ssa.BasicBlock) bool {
+	for _, s := range b.Succs {
+		if s.Dominates(b) {
+			return true
+		}
+	}
+	return false
+}
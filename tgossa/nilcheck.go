@@ -0,0 +1,141 @@
+// Copyright 2014 Elliott Stoneham and The TARDIS Go Authors
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file.
+
+package tgossa
+
+import (
+	"go/token"
+
+	"code.google.com/p/go.tools/go/ssa"
+)
+
+// IsNilCheckWrapper returns true if fn is one of the synthetic wrappers that the SSA code makes to call a value method
+// through a pointer, like (*T).m for the method m of T, which nil checks the pointer with the ssa:wrapnilchk builtin.
+// The wrapper is a single block that checks its first parameter, then makes one static call.
+// When a wrapper is inlined, the check can be left out if NonNil() proves that the caller's pointer cannot be nil.
+func IsNilCheckWrapper(fn *ssa.Function) bool {
+	if fn.Synthetic == "" || len(fn.Blocks) != 1 || fn.Recover != nil || len(fn.FreeVars) > 0 ||
+		len(fn.Params) == 0 || fn.Signature.Results().Len() > 1 {
+		return false
+	}
+	instrs := fn.Blocks[0].Instrs
+	if len(instrs) == 0 {
+		return false
+	}
+	if _, isRet := instrs[len(instrs)-1].(*ssa.Return); !isRet {
+		return false
+	}
+	checks, calls := 0, 0
+	for _, in := range instrs {
+		switch in.(type) {
+		case *ssa.Go, *ssa.Defer, *ssa.RunDefers, *ssa.Panic, *ssa.Send, *ssa.Select, *ssa.Phi:
+			return false
+		case *ssa.UnOp:
+			if in.(*ssa.UnOp).Op == token.ARROW {
+				return false
+			}
+		case *ssa.Call:
+			call := in.(*ssa.Call)
+			switch {
+			case isNilCheck(call):
+				if call.Call.Args[0] != ssa.Value(fn.Params[0]) {
+					return false
+				}
+				checks++
+			case call.Call.IsInvoke() || call.Call.StaticCallee() == nil:
+				return false
+			default:
+				if _, isBuiltin := call.Call.Value.(*ssa.Builtin); !isBuiltin {
+					calls++
+				}
+			}
+		}
+	}
+	return checks == 1 && calls == 1
+}
+
+// NilCheckIn returns the ssa:wrapnilchk call in the body of a wrapper for which IsNilCheckWrapper() is true.
+func NilCheckIn(wrapper *ssa.Function) *ssa.Call {
+	for _, in := range wrapper.Blocks[0].Instrs {
+		if isNilCheck(in) {
+			return in.(*ssa.Call)
+		}
+	}
+	return nil
+}
+
+// NonNil returns true if the pointer v cannot be nil when the instruction at, in the same function, is executed.
+// A pointer is known to be non-nil if it is: the address of an Alloc, Global, field or element;
+// the result of a nil check; the pointer checked by a nil check, or passed to a nil check wrapper, that is always executed before at;
+// or a phi of values that are all known to be non-nil.
+func NonNil(v ssa.Value, at ssa.Instruction) bool {
+	var checks []*ssa.Call // the nil checks in the function, including those made by the wrappers it calls
+	for _, b := range at.Parent().Blocks {
+		for _, in := range b.Instrs {
+			if isNilCheck(in) {
+				checks = append(checks, in.(*ssa.Call))
+			} else if call, isCall := in.(*ssa.Call); isCall && !call.Call.IsInvoke() &&
+				call.Call.StaticCallee() != nil && IsNilCheckWrapper(call.Call.StaticCallee()) {
+				checks = append(checks, call)
+			}
+		}
+	}
+	return nonNil(v, at, checks, make(map[*ssa.Phi]bool))
+}
+
+func isNilCheck(in ssa.Instruction) bool {
+	call, ok := in.(*ssa.Call)
+	if !ok || call.Call.IsInvoke() {
+		return false
+	}
+	bi, ok := call.Call.Value.(*ssa.Builtin)
+	return ok && bi.Name() == "ssa:wrapnilchk"
+}
+
+// nonNil returns true if v cannot be nil at the point of the instruction at.
+func nonNil(v ssa.Value, at ssa.Instruction, checks []*ssa.Call, seen map[*ssa.Phi]bool) bool {
+	switch v.(type) {
+	case *ssa.Alloc, *ssa.Global, *ssa.FieldAddr, *ssa.IndexAddr:
+		return true
+	case *ssa.Call:
+		if isNilCheck(v.(*ssa.Call)) {
+			return true
+		}
+	case *ssa.Phi:
+		phi := v.(*ssa.Phi)
+		if seen[phi] {
+			return true // a loop, where the other edges decide
+		}
+		seen[phi] = true
+		for _, e := range phi.Edges {
+			if !nonNil(e, at, checks, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, c := range checks { // is there an earlier check of the same value?
+		if c != at && c.Call.Args[0] == v && checkedBefore(c, at) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkedBefore is true if the instruction first is always executed before second.
+func checkedBefore(first, second ssa.Instruction) bool {
+	fb, sb := first.Block(), second.Block()
+	if fb != sb {
+		return fb.Dominates(sb)
+	}
+	for _, in := range fb.Instrs {
+		switch in {
+		case first:
+			return true
+		case second:
+			return false
+		}
+	}
+	return false
+}
@@ -27,22 +27,27 @@ import (
 //
 // Precondition: all packages are built.
 //
-func VisitedFunctions(prog *ssa.Program, packs []*ssa.Package /*new*/) (seen, usesGR map[*ssa.Function]bool) {
+// The prepare function (if not nil) is called for each function before it is visited,
+// so that optimizations which remove code (like ConstProp) reduce the functions visited.
+//
+func VisitedFunctions(prog *ssa.Program, packs []*ssa.Package /*new*/, prepare func(*ssa.Function) /*new*/) (seen, usesGR map[*ssa.Function]bool) {
 	visit := visitor{
-		prog:   prog,
-		packs:  packs, // new
-		seen:   make(map[*ssa.Function]bool),
-		usesGR: make(map[*ssa.Function]bool),
+		prog:    prog,
+		packs:   packs, // new
+		seen:    make(map[*ssa.Function]bool),
+		usesGR:  make(map[*ssa.Function]bool),
+		prepare: prepare, // new
 	}
 	visit.program()
 	return visit.seen, visit.usesGR
 }
 
 type visitor struct {
-	prog   *ssa.Program
-	packs  []*ssa.Package // new
-	seen   map[*ssa.Function]bool
-	usesGR map[*ssa.Function]bool // new
+	prog    *ssa.Program
+	packs   []*ssa.Package // new
+	seen    map[*ssa.Function]bool
+	usesGR  map[*ssa.Function]bool // new
+	prepare func(*ssa.Function)    // new
 }
 
 func (visit *visitor) program() {
@@ -72,6 +77,9 @@ func (visit *visitor) function(fn *ssa.Function) {
 		}
 		visit.seen[fn] = true
 		visit.usesGR[fn] = false
+		if visit.prepare != nil { // new
+			visit.prepare(fn)
+		}
 		var buf [10]*ssa.Value // avoid alloc in common case
 		for _, b := range fn.Blocks {
 			for _, instr := range b.Instrs {